@@ -0,0 +1,379 @@
+// batch.go renders a stream of JSON Lines or YAML documents, one
+// output file per record.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	// 3rd Party libraries
+	"gopkg.in/yaml.v3"
+)
+
+// batchRecord is a record read from a stream.
+type batchRecord struct {
+	n    int
+	line int
+	data map[string]interface{}
+	err  error
+}
+
+// BatchFailure describes a record which could not be rendered.
+type BatchFailure struct {
+	// Record is the position of the record in the stream, starting
+	// at 1
+	Record int
+
+	// Line is the line the record starts on
+	Line int
+
+	// Name is the output file name if it was worked out
+	Name string
+
+	// Err is why the record failed
+	Err error
+}
+
+// Error implements the error interface.
+func (f BatchFailure) Error() string {
+	if f.Name != "" {
+		return fmt.Sprintf("record %d (line %d, %s), %s", f.Record, f.Line, f.Name, f.Err)
+	}
+	return fmt.Sprintf("record %d (line %d), %s", f.Record, f.Line, f.Err)
+}
+
+// Unwrap returns the underlying error.
+func (f BatchFailure) Unwrap() error {
+	return f.Err
+}
+
+// BatchReport lists what RenderEach wrote and what failed.
+type BatchReport struct {
+	// Records is the number of records read
+	Records int
+
+	// Written holds the names of the files written in record order
+	Written []string
+
+	// Failed holds the records which could not be rendered
+	Failed []BatchFailure
+
+	// Unchanged holds the files which were up to date, see
+	// Renderer.Manifest
+	Unchanged []string
+
+	// Pruned holds the files removed because their record is no
+	// longer in the stream
+	Pruned []string
+
+	// Skipped counts the records not rendered because an earlier
+	// record failed, see Renderer.FailFast
+	Skipped int
+}
+
+// Summary describes the outcome, one failure per line.
+func (b *BatchReport) Summary() string {
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "rendered %d of %d records", len(b.Written), b.Records)
+	if len(b.Unchanged) > 0 {
+		fmt.Fprintf(sb, ", %d unchanged", len(b.Unchanged))
+	}
+	if len(b.Pruned) > 0 {
+		fmt.Fprintf(sb, ", %d pruned", len(b.Pruned))
+	}
+	if len(b.Failed) > 0 {
+		fmt.Fprintf(sb, ", %d failed", len(b.Failed))
+	}
+	if b.Skipped > 0 {
+		fmt.Fprintf(sb, ", %d skipped", b.Skipped)
+	}
+	for _, f := range b.Failed {
+		fmt.Fprintf(sb, "\n  %s", f.Error())
+	}
+	return sb.String()
+}
+
+// RenderEach renders each record of a JSON Lines or YAML multi-document
+// stream with the template and options writing one file per record,
+// see Renderer.RenderEach. It uses the settings of DefaultRenderer().
+//
+//```
+//  in, _ := os.Open("posts.jsonl")
+//  defer in.Close()
+//  report, err := pdtmpl.RenderEach(in, "post.tmpl", nil, "posts/{slug}.html")
+//  if err != nil {
+//      // ... the stream could not be read
+//  }
+//  fmt.Println(report.Summary())
+//```
+//
+func RenderEach(r io.Reader, template string, options []string, outPattern string) (*BatchReport, error) {
+	return DefaultRenderer().RenderEach(context.Background(), r, template, options, outPattern)
+}
+
+// RenderEach reads a stream of records, JSON Lines when the Renderer's
+// Format is FormatJSONL or FormatJSON, a YAML multi-document stream
+// ("---" between documents) when FormatYAML and guessed from the first
+// character otherwise. Each record is rendered with RenderData and
+// written to a file named by outPattern where "{field}" is replaced by
+// the record's field (dotted paths such as "{author.id}" are allowed)
+// and "{#}" by the record number.
+//
+// Records are rendered concurrently, see RenderJobs, the report lists
+// them in stream order. A record which can't be decoded, named or
+// rendered is added to the report's Failed list and the other records
+// are still rendered, unless the Renderer has FailFast set, then the
+// first failure stops the batch and is returned as the error. An error
+// is also returned when the stream itself can't be read.
+//
+// When the Renderer has a Manifest a record is only rendered when its
+// file is missing or the record, template, partials, options or Pandoc
+// version changed since the last run with the same outPattern, unless
+// Force is set. Files written by an earlier run for records no longer in
+// the stream are removed, provided every record was rendered.
+func (rd *Renderer) RenderEach(ctx context.Context, r io.Reader, template string, options []string, outPattern string) (*BatchReport, error) {
+	if outPattern == "" {
+		return nil, fmt.Errorf("missing output pattern")
+	}
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readRecords(src, rd.Format)
+	report := &BatchReport{Records: len(records)}
+	if err != nil {
+		return report, err
+	}
+	// Work out the names first so duplicates are found in stream
+	// order.
+	failures := make([]BatchFailure, len(records))
+	skipped := make([]bool, len(records))
+	owners := map[string]int{}
+	for i, rec := range records {
+		failure := BatchFailure{Record: rec.n, Line: rec.line, Err: rec.err}
+		if failure.Err == nil {
+			failure.Name, failure.Err = outputName(outPattern, rec)
+		}
+		if failure.Err == nil {
+			if n, ok := owners[failure.Name]; ok {
+				failure.Err = fmt.Errorf("same output as record %d", n)
+			}
+		}
+		if failure.Err == nil {
+			owners[failure.Name] = rec.n
+		}
+		failures[i] = failure
+	}
+	var (
+		m    *Manifest
+		deps *dependencies
+	)
+	if rd.Manifest != "" {
+		if m, err = ReadManifest(rd.Manifest); err != nil {
+			return report, err
+		}
+		deps = rd.newDependencies(m)
+	}
+	unchanged := make([]bool, len(records))
+	first := rd.runJobs(ctx, len(records), func(ctx context.Context, i int) error {
+		var entry *ManifestEntry
+		if failures[i].Err == nil && m != nil {
+			entry = deps.record(outPattern, records[i].data, template, options)
+			if !rd.Force && m.fresh(failures[i].Name, entry) {
+				unchanged[i] = true
+				return nil
+			}
+		}
+		if failures[i].Err == nil {
+			failures[i].Err = rd.renderRecord(ctx, records[i].data, template, options, failures[i].Name)
+		}
+		if failures[i].Err != nil {
+			if m != nil && failures[i].Name != "" {
+				m.set(failures[i].Name, nil)
+			}
+			return failures[i]
+		}
+		if m != nil {
+			m.set(failures[i].Name, entry)
+		}
+		return nil
+	}, func(i int) {
+		skipped[i] = true
+	})
+	for i, failure := range failures {
+		switch {
+		case skipped[i]:
+			report.Skipped++
+		case unchanged[i]:
+			report.Unchanged = append(report.Unchanged, failure.Name)
+		case failure.Err != nil:
+			report.Failed = append(report.Failed, failure)
+		default:
+			report.Written = append(report.Written, failure.Name)
+		}
+	}
+	if m != nil {
+		// Only prune when every record was rendered, a record which
+		// failed may still own its file.
+		if len(report.Failed) == 0 && report.Skipped == 0 && ctx.Err() == nil {
+			current := map[string]bool{}
+			for _, failure := range failures {
+				current[m.rel(failure.Name)] = true
+			}
+			report.Pruned, err = m.prune(func(target string, entry *ManifestEntry) bool {
+				return entry.Batch == outPattern && !current[target]
+			})
+		}
+		if err == nil {
+			err = m.Write()
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return report, first
+	}
+	// Give up when interrupted rather than listing every record.
+	if err := ctx.Err(); err != nil {
+		return report, interrupted(err)
+	}
+	return report, nil
+}
+
+// renderRecord renders a record and writes it to name.
+func (rd *Renderer) renderRecord(ctx context.Context, data map[string]interface{}, template string, options []string, name string) error {
+	src, err := rd.RenderData(ctx, data, template, options)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(name); dir != "." {
+		if err := os.MkdirAll(dir, 0775); err != nil {
+			return err
+		}
+	}
+	if rd.Logger != nil {
+		rd.Logger.Printf("writing %s", name)
+	}
+	return ioutil.WriteFile(name, src, 0664)
+}
+
+var rePatternField = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// outputName fills in an output pattern from a record.
+func outputName(pattern string, rec *batchRecord) (string, error) {
+	var err error
+	name := rePatternField.ReplaceAllStringFunc(pattern, func(m string) string {
+		field := m[1 : len(m)-1]
+		if field == "#" {
+			return fmt.Sprintf("%d", rec.n)
+		}
+		var v interface{} = rec.data
+		for _, part := range strings.Split(field, ".") {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = obj[part]
+		}
+		s := ""
+		switch v.(type) {
+		case nil, map[string]interface{}, []interface{}:
+		default:
+			s = recordName(fmt.Sprintf("%v", v))
+		}
+		if s == "" && err == nil {
+			err = fmt.Errorf("no usable %q for %q", field, pattern)
+		}
+		return s
+	})
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// readRecords reads all the records of a stream.
+func readRecords(src []byte, format string) ([]*batchRecord, error) {
+	if format == FormatAuto {
+		format = FormatYAML
+		if bytes.HasPrefix(bytes.TrimSpace(src), []byte("{")) {
+			format = FormatJSONL
+		}
+	}
+	switch format {
+	case FormatJSONL, FormatJSON:
+		return readJSONLines(bytes.NewReader(src))
+	case FormatYAML:
+		return readYAMLStream(bytes.NewReader(src))
+	}
+	return nil, fmt.Errorf("%s can't be read as a stream of records", format)
+}
+
+// readJSONLines reads one JSON object per non-blank line, a bad line
+// becomes a record holding the error.
+func readJSONLines(r io.Reader) ([]*batchRecord, error) {
+	records := []*batchRecord{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		rec := &batchRecord{n: len(records) + 1, line: line}
+		rec.data, rec.err = DecodeDocument(text, FormatJSON)
+		if de, ok := rec.err.(*DecodeError); ok {
+			de.Line = line
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// readYAMLStream reads the documents of a YAML stream. A syntax error
+// ends the stream so it is returned as an error.
+func readYAMLStream(r io.Reader) ([]*batchRecord, error) {
+	records := []*batchRecord{}
+	dec := yaml.NewDecoder(r)
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, &DecodeError{Format: FormatYAML, Msg: strings.TrimPrefix(err.Error(), "yaml: ")}
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		rec := &batchRecord{n: len(records) + 1, line: doc.Content[0].Line}
+		var v interface{}
+		if v, rec.err = yamlValue(&doc); rec.err == nil {
+			data, ok := v.(map[string]interface{})
+			if !ok {
+				rec.err = &DecodeError{Format: FormatYAML, Line: rec.line, Msg: fmt.Sprintf("expected a mapping, found %s", describeValue(v))}
+			}
+			rec.data = data
+		}
+		records = append(records, rec)
+	}
+}
@@ -0,0 +1,370 @@
+// check.go compares the variables a template uses with the fields of
+// a JSON or YAML document.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// KindMissing marks a variable with no matching field in the data
+	KindMissing = "missing"
+
+	// KindUnused marks a data field no template variable refers to
+	KindUnused = "unused"
+
+	// KindType marks a variable used in a way that doesn't fit its
+	// value, e.g. a for loop over a string
+	KindType = "type"
+)
+
+// writerVariables are set by Pandoc's writers, they are not expected
+// in the data.
+var writerVariables = map[string]bool{
+	"abstract-title":    true,
+	"author-meta":       true,
+	"body":              true,
+	"css":               true,
+	"date-meta":         true,
+	"dir":               true,
+	"document-css":      true,
+	"header-includes":   true,
+	"include-after":     true,
+	"include-before":    true,
+	"lang":              true,
+	"math":              true,
+	"meta-json":         true,
+	"outputfile":        true,
+	"pagetitle":         true,
+	"quotes":            true,
+	"sourcefile":        true,
+	"table-of-contents": true,
+	"toc":               true,
+	"toc-title":         true,
+}
+
+// CheckData decodes a JSON, YAML or TOML document (see DecodeDocument)
+// and checks it against the template file, see CheckTemplate. Keys are
+// rewritten first by the mapping set with SetKeyMapping, as the package
+// level render functions do.
+//
+//```
+//  src, _ := ioutil.ReadFile("codemeta.json")
+//  diagnostics, err := pdtmpl.CheckData(src, pdtmpl.FormatJSON, "codemeta-md.tmpl")
+//  if err != nil {
+//      // ... handle error
+//  }
+//  for _, d := range diagnostics {
+//      fmt.Println(d)
+//  }
+//```
+//
+func CheckData(src []byte, format string, template string) ([]Diagnostic, error) {
+	r := DefaultRenderer()
+	r.Format = format
+	return r.Check(src, template)
+}
+
+// Check decodes a document in the Renderer's Format and checks it
+// against the template file as Render would see it, the keys rewritten
+// by KeyMapping. See CheckTemplate.
+func (r *Renderer) Check(src []byte, template string) ([]Diagnostic, error) {
+	t, err := ReadTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	data, err := DecodeDocument(src, r.Format)
+	if err != nil {
+		return nil, err
+	}
+	if r.KeyMapping != nil {
+		data = NormalizeKeys(data, r.KeyMapping).(map[string]interface{})
+	}
+	return CheckTemplate(t, data), nil
+}
+
+// CheckTemplate compares the variables used by a template with data.
+// It reports variables with no matching field (KindMissing), fields
+// no variable refers to (KindUnused) and variables used in a way that
+// doesn't fit their value (KindType), e.g. a for loop over a string or
+// interpolating an object. Fields inside lists are named with "[]",
+// e.g. "author[].email". Diagnostics about the data have no file or
+// position. Variables Pandoc's writers set, such as "body" and "toc",
+// are not reported as missing.
+func CheckTemplate(t *Template, data map[string]interface{}) []Diagnostic {
+	root := newDataShape("")
+	root.merge(data)
+	// Pandoc's HTML writer derives pagetitle from title.
+	if _, ok := root.fields["title"]; ok {
+		if _, ok := root.fields["pagetitle"]; !ok {
+			root.field("pagetitle").all = true
+		}
+	}
+	c := &checker{name: t.Name, seen: map[string]bool{}}
+	c.walk(t.nodes, &shapeScope{shape: root})
+	root.used = true
+	c.unused(root)
+	return c.diagnostics
+}
+
+// dataShape describes the fields found at a path in the data, list
+// items are merged into a single shape.
+type dataShape struct {
+	path   string
+	kinds  map[string]bool
+	fields map[string]*dataShape
+	elem   *dataShape
+	// used is set when a variable refers to the path, all when the
+	// whole value is used
+	used bool
+	all  bool
+}
+
+func newDataShape(path string) *dataShape {
+	return &dataShape{path: path, kinds: map[string]bool{}, fields: map[string]*dataShape{}}
+}
+
+// field returns the shape of a map field creating it if needed.
+func (s *dataShape) field(name string) *dataShape {
+	f, ok := s.fields[name]
+	if !ok {
+		path := name
+		if s.path != "" {
+			path = s.path + "." + name
+		}
+		f = newDataShape(path)
+		s.fields[name] = f
+	}
+	return f
+}
+
+// merge adds a decoded value to the shape.
+func (s *dataShape) merge(v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		s.kinds["null"] = true
+	case bool:
+		s.kinds["bool"] = true
+	case []interface{}:
+		s.kinds["list"] = true
+		if s.elem == nil {
+			s.elem = newDataShape(s.path + "[]")
+		}
+		for _, item := range x {
+			s.elem.merge(item)
+		}
+	case map[string]interface{}:
+		s.kinds["map"] = true
+		for k, item := range x {
+			s.field(k).merge(item)
+		}
+	case map[interface{}]interface{}:
+		s.kinds["map"] = true
+		for k, item := range x {
+			s.field(fmt.Sprintf("%v", k)).merge(item)
+		}
+	default:
+		s.kinds["scalar"] = true
+	}
+}
+
+// scalar reports if the shape never holds a list or map.
+func (s *dataShape) scalar() bool {
+	return !s.kinds["list"] && !s.kinds["map"] && (s.kinds["scalar"] || s.kinds["bool"])
+}
+
+// describe names the kinds of value found at the path.
+func (s *dataShape) describe() string {
+	names := map[string]string{
+		"null":   "null",
+		"bool":   "a boolean",
+		"scalar": "a string or number",
+		"list":   "a list",
+		"map":    "an object",
+	}
+	kinds := []string{}
+	for k := range s.kinds {
+		kinds = append(kinds, names[k])
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, " or ")
+}
+
+// markAll marks the shape and everything inside it used.
+func (s *dataShape) markAll() {
+	s.used, s.all = true, true
+}
+
+// anyUsed reports if the shape or anything inside it is used.
+func (s *dataShape) anyUsed() bool {
+	if s.used || s.all {
+		return true
+	}
+	for _, f := range s.fields {
+		if f.anyUsed() {
+			return true
+		}
+	}
+	return s.elem != nil && s.elem.anyUsed()
+}
+
+// shapeScope binds a variable path to a shape like the scope used
+// when rendering, a nil shape is a value which can't be checked.
+type shapeScope struct {
+	parent *shapeScope
+	path   []string
+	shape  *dataShape
+}
+
+func (s *shapeScope) bind(path []string, shape *dataShape) *shapeScope {
+	return &shapeScope{parent: s, path: path, shape: shape}
+}
+
+// checker walks a template recording diagnostics.
+type checker struct {
+	name        string
+	seen        map[string]bool
+	diagnostics []Diagnostic
+}
+
+func (c *checker) report(kind string, pos position, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if c.seen[kind+msg] {
+		return
+	}
+	c.seen[kind+msg] = true
+	d := Diagnostic{Severity: SeverityWarning, Kind: kind, Msg: msg}
+	if pos.Line > 0 {
+		d.File, d.Line, d.Column = c.name, pos.Line, pos.Column
+	}
+	c.diagnostics = append(c.diagnostics, d)
+}
+
+// lookup finds the shape a variable refers to, it returns nil when
+// the shape is unknown or missing.
+func (c *checker) lookup(ctx *shapeScope, pos position, v *tvar) *dataShape {
+	for sc := ctx; sc != nil; sc = sc.parent {
+		if len(v.path) < len(sc.path) || strings.Join(v.path[:len(sc.path)], ".") != strings.Join(sc.path, ".") {
+			continue
+		}
+		cur := sc.shape
+		for _, part := range v.path[len(sc.path):] {
+			if cur == nil {
+				return nil
+			}
+			if !cur.kinds["map"] {
+				if len(cur.kinds) > 0 && !cur.kinds["null"] {
+					c.report(KindType, pos, "%q is %s, %q needs an object", cur.path, cur.describe(), v.String())
+				}
+				return nil
+			}
+			next, ok := cur.fields[part]
+			if !ok {
+				path := part
+				if cur.path != "" {
+					path = cur.path + "." + part
+				}
+				if !(cur.path == "" && writerVariables[part]) {
+					c.report(KindMissing, pos, "%q not found in data", path)
+				}
+				return nil
+			}
+			cur = next
+		}
+		return cur
+	}
+	return nil
+}
+
+// walk checks template nodes.
+func (c *checker) walk(nodes []tnode, ctx *shapeScope) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *interpNode:
+			s := c.lookup(ctx, n.pos, n.v)
+			if s == nil {
+				continue
+			}
+			s.markAll()
+			if len(n.v.pipes) > 0 {
+				continue
+			}
+			switch {
+			case s.kinds["map"] && !s.kinds["scalar"]:
+				c.report(KindType, n.pos, "%q is an object, interpolating it gives \"true\"", s.path)
+			case s.kinds["list"] && s.elem != nil && s.elem.kinds["map"] && !s.elem.kinds["scalar"]:
+				c.report(KindType, n.pos, "%q is a list of objects, use a for loop", s.path)
+			}
+		case *condNode:
+			if s := c.lookup(ctx, n.pos, n.v); s != nil {
+				s.used = true
+			}
+			c.walk(n.then, ctx)
+			c.walk(n.els, ctx)
+		case *loopNode:
+			s := c.lookup(ctx, n.pos, n.v)
+			var item *dataShape
+			switch {
+			case s == nil:
+			case len(n.v.pipes) > 0:
+				s.markAll()
+			default:
+				s.used = true
+				if s.scalar() {
+					c.report(KindType, n.pos, "for loop over %q which is %s, not a list", s.path, s.describe())
+				}
+				item = s
+				if s.kinds["list"] {
+					item = s.elem
+				}
+			}
+			inner := ctx.bind(n.v.path, item).bind([]string{"it"}, item)
+			c.walk(n.body, inner)
+			c.walk(n.sep, ctx)
+		case *partialNode:
+			c.walk(n.nodes, ctx)
+		case *nestNode:
+			c.walk(n.body, ctx)
+		}
+	}
+}
+
+// unused reports data fields no variable refers to.
+func (c *checker) unused(s *dataShape) {
+	if s.all {
+		return
+	}
+	if !s.anyUsed() {
+		c.report(KindUnused, position{}, "%q is not used by the template", s.path)
+		return
+	}
+	for _, name := range sortedFields(s) {
+		c.unused(s.fields[name])
+	}
+	// List items are checked field by field.
+	if s.elem != nil && !s.elem.all {
+		for _, name := range sortedFields(s.elem) {
+			c.unused(s.elem.fields[name])
+		}
+	}
+}
+
+// sortedFields returns the field names of a shape in order.
+func sortedFields(s *dataShape) []string {
+	names := make([]string, 0, len(s.fields))
+	for name := range s.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
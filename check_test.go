@@ -0,0 +1,43 @@
+// check_test.go checks comparing templates with data.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckKeyMapping(t *testing.T) {
+	template := filepath.Join(t.TempDir(), "page.tmpl")
+	if err := ioutil.WriteFile(template, []byte("$at__id$ $name$\n"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	src := []byte(`{"@id": "https://example.org", "name": "pdtmpl"}`)
+
+	// Without a mapping "@id" can't be reached by the template.
+	r := &Renderer{Format: FormatJSON}
+	diagnostics, err := r.Check(src, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 2 {
+		t.Errorf("expected at__id missing and @id unused, got %v", diagnostics)
+	}
+
+	r.KeyMapping = DefaultKeyMapping
+	diagnostics, err = r.Check(src, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) > 0 {
+		t.Errorf("expected no diagnostics with the key mapping, got %v", diagnostics)
+	}
+}
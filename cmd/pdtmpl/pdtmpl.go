@@ -1,12 +1,22 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/rsdoiel/pdtmpl"
 )
@@ -47,8 +57,91 @@ support verbs are
 help
 : Display this help page.
 
+doctor
+: Report on the installed Pandoc, its version, data directory, formats
+and which of the features used by {app_name} it supports.
+
+batch
+: Render each record of a JSON Lines file or YAML multi-document
+stream read from standard input (or "-i") with a template, writing one
+file per record. Takes the template name, an output pattern such as
+"posts/{slug}.html" and any Pandoc options. "{field}" in the pattern is
+replaced by the record's field, "{#}" by the record number. Records that
+fail are skipped and listed in a summary at the end, the exit status is
+1 if any failed. A manifest, ".pdtmpl-cache.json", kept in the directory
+at the start of the pattern (e.g. "posts") records what each file was
+rendered from, later runs only render the records which changed and
+remove the files of records no longer in the stream (see "-force").
+
+walk
+: Render a content tree, takes a source directory, a destination
+directory and any Pandoc options. Every ".json", ".yaml", ".yml" and ".md"
+file under the source directory is rendered into the same place under
+the destination with an ".html" extension (see "-ext"). A file's template
+is named by the "template" field of its front matter (or of the JSON or
+YAML document), otherwise it is a sidecar template with the same base
+name (e.g. "about.tmpl" for "about.md"), otherwise the "default.tmpl" of
+its directory or the nearest directory above it. Markdown files are
+converted by Pandoc, their front matter supplies the template variables.
+Hidden files and directories are skipped. Files that fail are listed in
+a summary at the end, the exit status is 1 if any failed. A manifest,
+".pdtmpl-cache.json" in the destination directory, records the hash of
+each file, its template, partials, the files named by Pandoc options
+(e.g. "--lua-filter"), the options and the Pandoc version. Later walks
+only render the files whose inputs changed and remove the pages of
+deleted files (see "-force").
+
+watch
+: Render a content tree as "walk" does then keep watching it, takes
+the same source directory, destination directory and Pandoc options.
+The source files, templates, partials and files named by options
+(e.g. "--lua-filter") are checked every "-interval", when they change,
+and have stopped changing, the pages depending on them are rendered
+again. Failures are reported and watching continues. Stop with Ctrl-C.
+
+serve
+: Preview a content tree in a web browser, takes the source directory
+and any Pandoc options. Pages are rendered when requested, "about.html"
+from "about.md", "about.json", "about.yaml" or "about.yml" with the
+"-template" or, without one, the template "walk" would choose. Other
+files such as "css/" and "webfonts/" are served as they are, templates
+and page sources are not served. Pages reload themselves when a source
+file, template, partial or Lua filter changes. Listens on "-addr".
+
+check
+: Compare the variables used by a template with the JSON or YAML
+document read from standard input (or "-i"). Reports variables missing
+from the data, data fields the template doesn't use and type mismatches
+such as a "for" loop over a string. Exits with status 1 when anything
+is reported.
+
+formschema
+: Read Markdown like "webform" and write a JSON object mapping each form's
+id to a JSON Schema describing its submissions, so a server can validate
+them with the same rules ("required", "pattern", "type", "min", "max",
+options).
+
+httpd
+: Run a rendering service for other applications, takes the directory
+templates may be loaded from. Clients POST a JSON, YAML or TOML document
+(the format is taken from the Content-Type), the "template" query
+parameter names the template and the other query parameters are Pandoc
+options, e.g. "/?template=page.tmpl&to=html5&toc". Only options which
+don't read or write files are accepted and Pandoc runs with "--sandbox",
+unless "-allow", "-deny" or "-root" are given. The response's
+Content-Type follows the "to" format. Documents larger than "-max-bytes"
+are refused and renders taking longer than "-timeout" (default 30s) are
+stopped. Listens on "-addr".
+
+lint
+: Check one or more Pandoc templates for unbalanced conditionals and
+loops, mixed delimiters, unknown pipes, unclosed "$" and missing
+partials. Reads the template from standard input when no template is
+given. Ends with a count of the errors and warnings, exits with status
+1 only when errors are found.
+
 tmpl
-: Apply the template preprosor for turning raw JSON and YAML into
+: Apply the template preprosor for turning raw JSON, YAML and TOML into
 a Markdown stream sent to Pandoc over standard io.
 
 webform
@@ -69,13 +162,129 @@ form object.
 
 -i INPUT
 : Is a text file with embedded YAML blocks to be transformed
-into HTML blocks (e.g. a Markdown file)
+into HTML blocks (e.g. a Markdown file), or the JSON, YAML or TOML
+document used by "tmpl" and "check"
+
+-from FORMAT
+: the format of the "tmpl", "check" and "batch" input, "json", "yaml",
+"toml", "csv", "tsv" or "jsonl". By default it comes from the extension
+of "-i" (".json", ".yaml", ".yml", ".toml", ".csv", ".tsv", ".jsonl" or
+".ndjson") otherwise JSON or YAML is guessed. CSV and TSV files need a
+header row, they are passed to the template as "columns", the column
+names, and "rows", one object per row
+
+-record-key COLUMN
+: with "tmpl", render each row on its own writing a file named by
+the row's COLUMN value into the "-o" directory (default the current
+directory). Every row which renders is written, the rows which fail
+are listed at the end and the exit status is 1
+
+-ext EXT
+: the file extension used with "-record-key" and "walk" (default ".html")
+
+-include GLOBS
+: a comma separated list of globs, "walk" only renders the files
+matching one of them. "*" matches within a directory name, "**" across
+directories, a glob without a "/" matches the file name (e.g. "*.md")
+
+-exclude GLOBS
+: a comma separated list of globs, "walk" skips the files and
+directories matching any of them (e.g. "drafts/**,*.draft.md")
+
+-addr ADDRESS
+: the address "serve" and "httpd" listen on (default ":8000")
+
+-template FILE
+: the template "serve" renders every page with, instead of the one
+"walk" would choose
+
+-max-bytes N
+: the largest document "httpd" accepts (default 1048576)
+
+-interval DURATION
+: how often "watch" and "serve" check for changed files (default "500ms")
+
+-force
+: render everything with "walk" and "batch", ignoring the manifest of
+what is up to date
+
+-dry-run
+: list what "walk" would render, each source, target and template,
+without rendering anything
+
+-jobs N
+: the number of renders "batch", "walk" and "-record-key" run at once,
+defaults to the number of CPUs
+
+-fail-fast
+: stop "batch", "walk" and "-record-key" at the first failure instead of
+rendering the remaining records
 
 -o OUTPUT
 : write Pandoc output to file
 
+-timeout DURATION
+: stop Pandoc if it has not finished after DURATION (e.g. "30s", "2m"),
+the default of zero means wait indefinitely
+
+-transport NAME
+: how the JSON or YAML document is passed to Pandoc, one of "auto",
+"tempdir", "dir", "pipe" or "stdin" (default "auto")
+
+-tmpdir DIR
+: write the temporary metadata file to DIR, implies "-transport dir"
+
+-engine NAME
+: render templates with "pandoc", the "native" Go implementation of
+Pandoc templates or "auto" which uses native when no Pandoc options
+are given (default "pandoc")
+
+-normalize-keys
+: rewrite keys which can't be Pandoc variables, "@id" becomes "at__id"
+(used by "tmpl" and "check")
+
+-key-map MAPPING
+: rewrite key characters using a comma separated list of FROM=TO pairs
+(e.g. "@=at__,$=dollar__"), implies "-normalize-keys"
+
+-allow OPTIONS
+: a comma separated list of the only Pandoc options accepted after
+the template name (e.g. "--toc,--to,-s"), turns on the option policy
+
+-deny OPTIONS
+: a comma separated list of Pandoc options refused, turns on the option
+policy. The default refuses options which run programs, write files or
+read files other than the document (e.g. "--lua-filter", "-o",
+"--template", "--include-in-header")
+
+-root DIR
+: with the option policy, the template, input files and path options
+must be inside DIR, turns on the option policy. Without it input files
+and options naming files (e.g. "--bibliography") are refused
+
+-no-sandbox
+: with the option policy, don't run Pandoc with "--sandbox" (Pandoc
+2.15 or newer is needed for "--sandbox")
+
+-strict
+: with webform, fail when a control has no label, aria-label or title,
+or a fieldset or group has no legend
+
+-json
+: write check and lint diagnostics as a JSON array
+
 # EXAMPLES
 
+Check a template before using it, print the problems as JSON
+for an editor.
+
+  {app_name} -json lint bad.tmpl
+
+Report the fields of "codemeta.json" which "codemeta-md.tmpl"
+doesn't use and the template variables with no data.
+
+  {app_name} -i codemeta.json check codemeta-md.tmpl
+
 In this example we have a JSON object document called
 "example.json" and a Pandoc template called "example.tmpl".
 A redirect "<" is used to pipe the content of "example.json"
@@ -97,6 +306,55 @@ marked up to produce Markdown output).
   {app_name} -i codemeta.json -o about.md tmpl \
              codemeta-md.tmpl
 
+Site settings kept in TOML work too, the format comes from the
+file extension or "-from toml".
+
+  {app_name} -i site.toml tmpl page.tmpl
+  {app_name} -from toml tmpl page.tmpl < site.toml
+
+Render a page for each person in "staff.csv", named by the
+"id" column, into the "staff" directory.
+
+  {app_name} -i staff.csv -record-key id -o staff tmpl staff.tmpl
+
+Render a page for each post in "posts.jsonl" naming each
+file from the post's "slug".
+
+  {app_name} -i posts.jsonl batch post.tmpl "posts/{slug}.html"
+
+Preview the "content" tree at http://localhost:8000/ while editing.
+
+  {app_name} serve content
+
+Render pages for other applications with the templates in
+"/usr/local/share/pdtmpl", a client POSTs the JSON document.
+
+  {app_name} -addr localhost:8080 httpd /usr/local/share/pdtmpl
+  curl --data-binary @page.json -H "Content-Type: application/json" \
+       "http://localhost:8080/?template=page.tmpl&to=html5"
+
+Only let a script choose the output format and a table of
+contents, anything else is refused.
+
+  {app_name} -allow "--to,--toc" tmpl page.tmpl $USER_OPTIONS < page.json
+
+Keep "htdocs" up to date while editing the content or its templates.
+
+  {app_name} watch content htdocs
+
+Render the "content" tree into "htdocs", leaving out the drafts.
+List the plan first with "-dry-run".
+
+  {app_name} -exclude "drafts/**" -dry-run walk content htdocs
+  {app_name} -exclude "drafts/**" walk content htdocs
+
+The "@id", "@type" and "@context" keys of "codemeta.json" can't
+be used in a template, "-normalize-keys" makes them available as
+"at__id", "at__type" and "at__context".
+
+  {app_name} -normalize-keys -i codemeta.json -o about.md \
+             tmpl codemeta-about.tmpl
+
 
 In this example we have a markdown file called "guestbook.md"
 is processed and then sent through Pandoc to render as HTML.
@@ -106,6 +364,13 @@ is processed and then sent through Pandoc to render as HTML.
   >guestbook.html
 ~~~
 
+The server receiving the guestbook submissions can check them against
+the JSON Schema of the same form.
+
+~~~shell
+{app_name} formschema < guestbook.md > guestbook-schema.json
+~~~
+
 `
 
 )
@@ -118,6 +383,233 @@ func handleError(eout io.Writer, err error) {
 	}
 }
 
+// doctor prints a report on the installed Pandoc.
+func doctor(out io.Writer, eout io.Writer) error {
+	r, err := pdtmpl.NewRenderer()
+	if err != nil {
+		fmt.Fprintf(eout, "pandoc not found, %s\n", err)
+		return err
+	}
+	info, err := r.PandocInfo()
+	if err != nil {
+		fmt.Fprintf(eout, "%s\n", err)
+		return err
+	}
+	fmt.Fprintf(out, "pandoc: %s\n", info.Path)
+	fmt.Fprintf(out, "version: %s\n", info.Version)
+	fmt.Fprintf(out, "user data directory: %s\n", info.DataDir)
+	fmt.Fprintf(out, "input formats: %s\n", strings.Join(info.InputFormats, ", "))
+	fmt.Fprintf(out, "output formats: %s\n", strings.Join(info.OutputFormats, ", "))
+	fmt.Fprintf(out, "extensions: %d\n", len(info.Extensions))
+	fmt.Fprintf(out, "metadata transport: %s\n", r.Metadata.Resolve())
+	fmt.Fprintf(out, "features:\n")
+	for _, f := range pdtmpl.Features {
+		status := "ok"
+		if !info.Supports(f.Name) {
+			status = "missing"
+		}
+		fmt.Fprintf(out, "  [%s] %s (Pandoc %s)\n", status, f.Description, f.MinVersion)
+	}
+	if !info.AtLeast(pdtmpl.MinimumPandocVersion) {
+		err = fmt.Errorf("Pandoc %s is older than %s, please upgrade", info.Version, pdtmpl.MinimumPandocVersion)
+		fmt.Fprintf(eout, "%s\n", err)
+		return err
+	}
+	return nil
+}
+
+// writeDiagnostics writes diagnostics as text or JSON.
+func writeDiagnostics(out io.Writer, diagnostics []pdtmpl.Diagnostic, asJSON bool) error {
+	if asJSON {
+		src, err := json.MarshalIndent(diagnostics, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", src)
+	} else {
+		for _, d := range diagnostics {
+			fmt.Fprintf(out, "%s\n", d)
+		}
+	}
+	return nil
+}
+
+// lint checks templates writing diagnostics to out and a count of
+// them to eout, it returns an error when any diagnostic is an error.
+func lint(in io.Reader, out io.Writer, eout io.Writer, names []string, asJSON bool) error {
+	diagnostics := []pdtmpl.Diagnostic{}
+	if len(names) == 0 {
+		diagnostics = append(diagnostics, pdtmpl.LintTemplate(in)...)
+	}
+	for _, name := range names {
+		diagnostics = append(diagnostics, pdtmpl.LintFile(name)...)
+	}
+	if err := writeDiagnostics(out, diagnostics, asJSON); err != nil {
+		return err
+	}
+	errors := 0
+	for _, d := range diagnostics {
+		if d.Severity == pdtmpl.SeverityError {
+			errors++
+		}
+	}
+	summary := fmt.Sprintf("%d error(s), %d warning(s)", errors, len(diagnostics)-errors)
+	if errors > 0 {
+		return fmt.Errorf("%s", summary)
+	}
+	if len(diagnostics) > 0 {
+		fmt.Fprintf(eout, "%s\n", summary)
+	}
+	return nil
+}
+
+// check compares a template with a data document, with its keys
+// rewritten as the renderer would, it returns an error when anything
+// is reported.
+func check(r *pdtmpl.Renderer, in io.Reader, out io.Writer, inputName string, template string, asJSON bool) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	diagnostics, err := r.Check(src, template)
+	if err != nil {
+		return err
+	}
+	for i := range diagnostics {
+		if diagnostics[i].File == "" {
+			diagnostics[i].File = inputName
+		}
+	}
+	if err := writeDiagnostics(out, diagnostics, asJSON); err != nil {
+		return err
+	}
+	if len(diagnostics) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(diagnostics))
+	}
+	return nil
+}
+
+// renderRecords renders each row of the input to its own file in dir.
+func renderRecords(ctx context.Context, r *pdtmpl.Renderer, in io.Reader, dir string, key string, ext string, template string, options []string) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	data, err := pdtmpl.DecodeDocument(src, r.Format)
+	if err != nil {
+		return err
+	}
+	names, records, err := pdtmpl.SplitRecords(data, key)
+	if err != nil {
+		return err
+	}
+	if dir == "" || dir == "-" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return err
+	}
+	jobs := make([]pdtmpl.Job, len(records))
+	for i, record := range records {
+		jobs[i] = pdtmpl.Job{Data: record, Template: template, Options: options}
+	}
+	// Write every record which rendered then report the failures in
+	// row order, as the batch verb does.
+	results, err := r.RenderJobs(ctx, jobs)
+	written, skipped, failures := 0, 0, []string{}
+	for i, res := range results {
+		switch {
+		case res.Err != nil:
+			failures = append(failures, fmt.Sprintf("%s, %s", names[i], res.Err))
+		case res.Skipped:
+			skipped++
+		default:
+			fName := filepath.Join(dir, names[i]+ext)
+			if r.Logger != nil {
+				r.Logger.Printf("writing %s", fName)
+			}
+			if err := ioutil.WriteFile(fName, res.Out, 0664); err != nil {
+				failures = append(failures, fmt.Sprintf("%s, %s", names[i], err))
+				continue
+			}
+			written++
+		}
+	}
+	if len(failures) == 0 && skipped == 0 {
+		return err
+	}
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "rendered %d of %d records, %d failed", written, len(records), len(failures))
+	if skipped > 0 {
+		fmt.Fprintf(sb, ", %d skipped", skipped)
+	}
+	for _, failure := range failures {
+		fmt.Fprintf(sb, "\n  %s", failure)
+	}
+	return fmt.Errorf("%s", sb.String())
+}
+
+// splitList splits a comma separated list dropping empty items.
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// walkPlan lists what walk would render, it returns an error when a
+// file can't be rendered.
+func walkPlan(out io.Writer, r *pdtmpl.Renderer, src string, dst string, opts *pdtmpl.WalkOptions) error {
+	items, err := r.WalkPlan(src, dst, opts)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, item := range items {
+		if item.Err != nil {
+			fmt.Fprintf(out, "%s, error %s\n", item.Source, item.Err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "%s -> %s (%s)\n", item.Source, item.Target, item.Template)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files can't be rendered", failed, len(items))
+	}
+	return nil
+}
+
+// listen serves handler until ctx is cancelled.
+func listen(ctx context.Context, eout io.Writer, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		// Requests end with ctx so streams such as the preview's
+		// reload events don't hold up the shutdown.
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	host := addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	fmt.Fprintf(eout, "listening on http://%s/, Ctrl-C to stop\n", host)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
 func main() {
 	var (
 		showHelp    bool
@@ -126,13 +618,38 @@ func main() {
 		verbose     bool
 		input       string
 		output      string
+		timeout     time.Duration
+		transport   string
+		tmpDir      string
+		engine      string
+		asJSON      bool
+		normalize   bool
+		keyMap      string
+		from        string
+		recordKey   string
+		jobs        int
+		failFast    bool
+		ext         string
+		include     string
+		exclude     string
+		dryRun      bool
+		force       bool
+		interval    time.Duration
+		addr        string
+		maxBytes    int64
+		allow       string
+		deny        string
+		root        string
+		noSandbox   bool
+		strict      bool
+		tmplName    string
 		err         error
 	)
 
 	appName := path.Base(os.Args[0])
 	licenseText := pdtmpl.LicenseText
 	version, releaseHash, releaseDate := pdtmpl.Version, pdtmpl.ReleaseHash, pdtmpl.ReleaseDate
-	verb, verbs := "help", []string{ "help", "tmpl", "webform" }
+	verb, verbs := "help", []string{ "help", "doctor", "batch", "check", "formschema", "httpd", "lint", "tmpl", "serve", "walk", "watch", "webform" }
 	fmtHelp := pdtmpl.FmtHelp
 	
 	flag.BoolVar(&showHelp, "help", false, "display usage")
@@ -141,6 +658,31 @@ func main() {
 	flag.BoolVar(&verbose, "verbose", false, "show Pandoc envocation")
 	flag.StringVar(&input, "i", "", "read JSON or YAML from file")
 	flag.StringVar(&output, "o", "", "write Pandoc output to file")
+	flag.DurationVar(&timeout, "timeout", 0, "stop Pandoc after duration (e.g. 30s)")
+	flag.StringVar(&transport, "transport", "auto", "pass metadata via auto, tempdir, dir, pipe or stdin")
+	flag.StringVar(&tmpDir, "tmpdir", "", "directory for the temporary metadata file")
+	flag.StringVar(&engine, "engine", "pandoc", "render with pandoc, native or auto")
+	flag.StringVar(&from, "from", "", "input format, json, yaml or toml (default from the -i extension)")
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), "number of renders to run at once")
+	flag.BoolVar(&failFast, "fail-fast", false, "stop at the first failed render")
+	flag.StringVar(&recordKey, "record-key", "", "render each row to a file named by this column")
+	flag.StringVar(&ext, "ext", ".html", "file extension used with -record-key and walk")
+	flag.StringVar(&include, "include", "", "walk only files matching these comma separated globs")
+	flag.StringVar(&exclude, "exclude", "", "walk skips files matching these comma separated globs")
+	flag.BoolVar(&dryRun, "dry-run", false, "list what walk would render")
+	flag.DurationVar(&interval, "interval", pdtmpl.DefaultWatchInterval, "how often watch and serve check for changes")
+	flag.StringVar(&addr, "addr", ":8000", "address serve and httpd listen on")
+	flag.Int64Var(&maxBytes, "max-bytes", pdtmpl.DefaultMaxBytes, "largest document httpd accepts")
+	flag.BoolVar(&force, "force", false, "render everything, ignore the walk and batch manifest")
+	flag.BoolVar(&normalize, "normalize-keys", false, "rewrite keys which can't be Pandoc variables")
+	flag.StringVar(&keyMap, "key-map", "", "rewrite keys with FROM=TO pairs, e.g. @=at__")
+	flag.StringVar(&allow, "allow", "", "only accept these comma separated Pandoc options")
+	flag.StringVar(&deny, "deny", "", "refuse these comma separated Pandoc options")
+	flag.StringVar(&root, "root", "", "keep templates and path options inside this directory")
+	flag.BoolVar(&noSandbox, "no-sandbox", false, "don't run Pandoc with --sandbox under the option policy")
+	flag.StringVar(&tmplName, "template", "", "render every page served with this template")
+	flag.BoolVar(&strict, "strict", false, "fail a webform with a control missing a label")
+	flag.BoolVar(&asJSON, "json", false, "write check and lint diagnostics as JSON")
 	flag.Parse()
 
 	in := os.Stdin
@@ -167,31 +709,222 @@ func main() {
 
 	}
 	verb, args = args[0], args[1:]
-	pdtmpl.SetVerbose(verbose)
 
 	if input != "" && input != "-" {
 		in, err = os.Open(input)
 		handleError(eout, err)
 		defer in.Close()
 	}
-	if output != "" && output != "-" {
+	// With -record-key the output is a directory.
+	if output != "" && output != "-" && recordKey == "" {
 		out, err = os.Create(output)
 		handleError(eout, err)
 		defer out.Close()
 	}
 
+	// The input format is given by -from or the -i file extension.
+	format, err := pdtmpl.ParseFormat(from)
+	handleError(eout, err)
+	if format == pdtmpl.FormatAuto && input != "" {
+		format = pdtmpl.FormatFromExt(input)
+	}
+
+	// newRenderer sets up a Renderer from the options. Pandoc is
+	// looked up when needed, the native engine runs without it.
+	newRenderer := func() *pdtmpl.Renderer {
+		r := &pdtmpl.Renderer{}
+		r.Engine, err = pdtmpl.ParseEngine(engine)
+		handleError(eout, err)
+		r.Format = format
+		r.Timeout = timeout
+		r.Jobs = jobs
+		r.FailFast = failFast
+		r.Force = force
+		if verbose {
+			r.Logger = log.New(eout, "", 0)
+		}
+		r.Metadata.Dir = tmpDir
+		r.Metadata.Transport, err = pdtmpl.ParseTransport(transport)
+		handleError(eout, err)
+		if tmpDir != "" {
+			r.Metadata.Transport = pdtmpl.TransportDir
+		}
+		if keyMap != "" {
+			r.KeyMapping, err = pdtmpl.ParseKeyMapping(keyMap)
+			handleError(eout, err)
+		} else if normalize {
+			r.KeyMapping = pdtmpl.DefaultKeyMapping
+		}
+		if allow != "" || deny != "" || root != "" {
+			r.Policy = &pdtmpl.OptionPolicy{
+				Allow:     splitList(allow),
+				Deny:      splitList(deny),
+				Root:      root,
+				NoSandbox: noSandbox,
+			}
+		}
+		return r
+	}
+
 	switch verb {
 	case "help":
 		fmt.Fprintf(out, "%s", fmtHelp(helpText, appName, version, releaseHash, releaseDate))
 		os.Exit(0)
+	case "doctor":
+		if err := doctor(out, eout); err != nil {
+			os.Exit(1)
+		}
+	case "check":
+		if len(args) != 1 {
+			handleError(eout, fmt.Errorf("expected a template name"))
+		}
+		inputName := input
+		if inputName == "" {
+			inputName = "-"
+		}
+		err := check(newRenderer(), in, out, inputName, args[0], asJSON)
+		handleError(eout, err)
+	case "lint":
+		err := lint(in, out, eout, args, asJSON)
+		handleError(eout, err)
 	case "tmpl":
 		if len(args) == 0 {
 			handleError(eout, fmt.Errorf("missing template name"))
 		}
-		err = pdtmpl.ApplyIOTemplate(in, out, args[0], args[1:])
+		r := newRenderer()
+		// Pandoc runs in its own process group so we stop it ourselves
+		// on interrupt.
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		if recordKey != "" {
+			err := renderRecords(ctx, r, in, output, recordKey, ext, args[0], args[1:])
+			handleError(eout, err)
+			break
+		}
+		src, err := r.RenderReader(ctx, in, args[0], args[1:])
+		handleError(eout, err)
+		fmt.Fprintf(out, "%s\n", src)
+	case "batch":
+		if len(args) < 2 {
+			handleError(eout, fmt.Errorf("expected a template name and an output pattern"))
+		}
+		r := newRenderer()
+		// Keep the manifest where the pattern's fixed part points.
+		prefix := args[1]
+		if i := strings.Index(prefix, "{"); i >= 0 {
+			prefix = prefix[:i]
+		}
+		r.Manifest = filepath.Join(filepath.Dir(prefix), pdtmpl.ManifestName)
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		report, err := r.RenderEach(ctx, in, args[0], args[2:], args[1])
+		if report == nil {
+			handleError(eout, err)
+		}
+		// The summary lists the failures, -fail-fast returns the
+		// first as err too.
+		fmt.Fprintf(eout, "%s\n", report.Summary())
+		if len(report.Failed) > 0 {
+			os.Exit(1)
+		}
+		handleError(eout, err)
+	case "walk":
+		if len(args) < 2 {
+			handleError(eout, fmt.Errorf("expected a source and a destination directory"))
+		}
+		r := newRenderer()
+		opts := &pdtmpl.WalkOptions{
+			Include: splitList(include),
+			Exclude: splitList(exclude),
+			Ext:     ext,
+			Options: args[2:],
+		}
+		if dryRun {
+			err := walkPlan(out, r, args[0], args[1], opts)
+			handleError(eout, err)
+			break
+		}
+		r.Manifest = filepath.Join(args[1], pdtmpl.ManifestName)
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		report, err := r.Walk(ctx, args[0], args[1], opts)
+		if report == nil {
+			handleError(eout, err)
+		}
+		fmt.Fprintf(eout, "%s\n", report.Summary())
+		if len(report.Failed) > 0 {
+			os.Exit(1)
+		}
+		handleError(eout, err)
+	case "watch":
+		if len(args) < 2 {
+			handleError(eout, fmt.Errorf("expected a source and a destination directory"))
+		}
+		r := newRenderer()
+		r.Manifest = filepath.Join(args[1], pdtmpl.ManifestName)
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		err := r.Watch(ctx, args[0], args[1], &pdtmpl.WatchOptions{
+			Walk: pdtmpl.WalkOptions{
+				Include: splitList(include),
+				Exclude: splitList(exclude),
+				Ext:     ext,
+				Options: args[2:],
+			},
+			Interval: interval,
+			Changed: func(files []string) {
+				fmt.Fprintf(eout, "changed %s\n", strings.Join(files, ", "))
+			},
+			Rendered: func(report *pdtmpl.WalkReport, err error) {
+				if report != nil {
+					fmt.Fprintf(eout, "%s\n", report.Summary())
+				}
+				if err != nil && (report == nil || len(report.Failed) == 0) {
+					fmt.Fprintf(eout, "error, %s\n", err)
+				}
+			},
+		})
+		handleError(eout, err)
+	case "serve":
+		if len(args) < 1 {
+			handleError(eout, fmt.Errorf("expected a source directory"))
+		}
+		r := newRenderer()
+		preview := pdtmpl.NewPreview(r, args[0])
+		preview.Ext = ext
+		preview.Options = args[1:]
+		preview.Template = tmplName
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		go preview.Watch(ctx, interval)
+		err := listen(ctx, eout, addr, preview)
+		handleError(eout, err)
+	case "httpd":
+		if len(args) != 1 {
+			handleError(eout, fmt.Errorf("expected a template directory"))
+		}
+		info, err := os.Stat(args[0])
+		handleError(eout, err)
+		if !info.IsDir() {
+			handleError(eout, fmt.Errorf("%s is not a directory", args[0]))
+		}
+		r := newRenderer()
+		if r.Policy == nil && noSandbox {
+			// Keep the handler's allow list, only drop --sandbox.
+			r.Policy = &pdtmpl.OptionPolicy{Allow: pdtmpl.HandlerOptions, NoSandbox: true}
+		}
+		h := pdtmpl.NewHandler(r)
+		h.TemplateDir = args[0]
+		h.MaxBytes = maxBytes
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		err = listen(ctx, eout, addr, h)
+		handleError(eout, err)
+	case "formschema":
+		err := pdtmpl.ApplyFormSchema(in, out, eout)
 		handleError(eout, err)
 	case "webform":
-		err := pdtmpl.ApplyWebForm(in, out, eout, args)
+		err := pdtmpl.ApplyWebFormWithOptions(in, out, eout, args, &pdtmpl.WebFormOptions{Strict: strict})
 		handleError(eout, err)
 	default:
 		fmt.Fprintf(eout, "error, expected %s, see %s help for details", strings.Join(verbs, ", "), appName)
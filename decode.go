@@ -0,0 +1,419 @@
+// decode.go decodes and validates the JSON, YAML and TOML documents
+// handed to templates.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// 3rd Party libraries
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FormatAuto guesses the format, documents starting with "{" or
+	// "[" are JSON, anything else YAML
+	FormatAuto = ""
+
+	// FormatJSON is a JSON document
+	FormatJSON = "json"
+
+	// FormatYAML is a YAML document
+	FormatYAML = "yaml"
+
+	// FormatTOML is a TOML document
+	FormatTOML = "toml"
+
+	// FormatCSV is a comma separated table with a header row
+	FormatCSV = "csv"
+
+	// FormatTSV is a tab separated table with a header row
+	FormatTSV = "tsv"
+
+	// FormatJSONL is a stream of JSON objects, one per line, see
+	// RenderEach
+	FormatJSONL = "jsonl"
+)
+
+// formatExtensions maps file extensions to formats.
+var formatExtensions = map[string]string{
+	".json":   FormatJSON,
+	".yaml":   FormatYAML,
+	".yml":    FormatYAML,
+	".toml":   FormatTOML,
+	".csv":    FormatCSV,
+	".tsv":    FormatTSV,
+	".jsonl":  FormatJSONL,
+	".ndjson": FormatJSONL,
+}
+
+// FormatFromExt returns the format implied by a file name's extension,
+// FormatAuto when the extension isn't known.
+func FormatFromExt(name string) string {
+	return formatExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// ParseFormat checks an input format name, e.g. from a command line
+// option. "auto" and "" are FormatAuto.
+func ParseFormat(name string) (string, error) {
+	switch format := strings.ToLower(name); format {
+	case "", "auto":
+		return FormatAuto, nil
+	case FormatJSON, FormatYAML, FormatTOML, FormatCSV, FormatTSV, FormatJSONL:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown input format %q", name)
+}
+
+// DecodeError reports a problem with an input document.
+type DecodeError struct {
+	// Format is the format of the document, e.g. "json" or "yaml"
+	Format string
+
+	// Line and Column of the problem, starting at 1. Column is zero
+	// when not known.
+	Line   int
+	Column int
+
+	// Msg describes the problem
+	Msg string
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	format := strings.ToUpper(e.Format)
+	switch {
+	case e.Line == 0:
+		return fmt.Sprintf("invalid %s, %s", format, e.Msg)
+	case e.Column == 0:
+		return fmt.Sprintf("invalid %s at line %d, %s", format, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("invalid %s at line %d, column %d, %s", format, e.Line, e.Column, e.Msg)
+}
+
+// DetectFormat guesses the format of a document.
+func DetectFormat(src []byte) string {
+	trimmed := bytes.TrimSpace(src)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// DecodeDocument decodes a JSON, YAML or TOML document which must hold
+// an object, an empty document is an empty object. FormatAuto guesses
+// between JSON and YAML, other formats have to be asked for. CSV and
+// TSV tables become an object holding "columns" and "rows". Syntax errors and
+// documents which are not objects are reported as a *DecodeError with
+// the line and column of the problem. Numbers and dates become the
+// text they were written with.
+//
+//```
+//  src, _ := ioutil.ReadFile("example.yaml")
+//  data, err := pdtmpl.DecodeDocument(src, pdtmpl.FormatAuto)
+//  if err != nil {
+//      // ... e.g. invalid YAML at line 3, column 7, ...
+//  }
+//```
+//
+func DecodeDocument(src []byte, format string) (map[string]interface{}, error) {
+	if format == FormatAuto {
+		format = DetectFormat(src)
+	}
+	var (
+		v   interface{}
+		err error
+	)
+	switch format {
+	case FormatJSON:
+		v, err = decodeJSON(src)
+	case FormatYAML:
+		v, err = decodeYAML(src)
+	case FormatTOML:
+		v, err = decodeTOML(src)
+	case FormatCSV, FormatTSV:
+		v, err = decodeTable(src, format)
+	case FormatJSONL:
+		return nil, fmt.Errorf("%s holds many documents, see RenderEach", format)
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return map[string]interface{}{}, nil
+	}
+	return v.(map[string]interface{}), nil
+}
+
+// CanonicalJSON encodes a decoded document as JSON with sorted keys,
+// this is the form passed to Pandoc.
+func CanonicalJSON(data map[string]interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// describeValue names the type of a decoded value for error messages.
+func describeValue(v interface{}) string {
+	switch v.(type) {
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// lineColumn converts a byte offset into a line and column.
+func lineColumn(src []byte, offset int64) (int, int) {
+	if offset > int64(len(src)) {
+		offset = int64(len(src))
+	}
+	before := src[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len([]rune(string(before[bytes.LastIndexByte(before, '\n')+1:])))
+	return line, col
+}
+
+// decodeJSON decodes a JSON object keeping numbers as json.Number.
+func decodeJSON(src []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(src)) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		derr := &DecodeError{Format: FormatJSON, Msg: err.Error()}
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			derr.Line, derr.Column = lineColumn(src, e.Offset)
+		default:
+			if err == io.ErrUnexpectedEOF {
+				derr.Line, derr.Column = lineColumn(src, int64(len(src)))
+				derr.Msg = "unexpected end of document"
+			}
+		}
+		return nil, derr
+	}
+	start := int64(len(src) - len(bytes.TrimLeft(src, " \t\r\n")))
+	if _, ok := v.(map[string]interface{}); !ok {
+		line, col := lineColumn(src, start+1)
+		return nil, &DecodeError{Format: FormatJSON, Line: line, Column: col, Msg: fmt.Sprintf("expected an object, found %s", describeValue(v))}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		line, col := lineColumn(src, dec.InputOffset())
+		return nil, &DecodeError{Format: FormatJSON, Line: line, Column: col, Msg: "unexpected data after the object"}
+	}
+	return v, nil
+}
+
+var (
+	reErrorLine  = regexp.MustCompile(`line (\d+)[^:]*: (.*)`)
+	reJSONNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// decodeYAML decodes a YAML mapping.
+func decodeYAML(src []byte) (interface{}, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		derr := &DecodeError{Format: FormatYAML, Msg: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := reErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			derr.Line, _ = strconv.Atoi(m[1])
+			derr.Msg = m[2]
+		}
+		return nil, derr
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	v, err := yamlValue(doc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		n := doc.Content[0]
+		return nil, &DecodeError{Format: FormatYAML, Line: n.Line, Column: n.Column, Msg: fmt.Sprintf("expected a mapping, found %s", describeValue(v))}
+	}
+	return v, nil
+}
+
+// yamlValue converts a YAML node to the values produced by decoding
+// JSON. Scalars keep their text so a date stays "2022-07-08" and a
+// number stays "1.0".
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(n.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(n.Content))
+		for i, item := range n.Content {
+			v, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	case yaml.MappingNode:
+		m := map[string]interface{}{}
+		lines := map[string]int{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, item := n.Content[i], n.Content[i+1]
+			if k.Kind == yaml.AliasNode {
+				k = k.Alias
+			}
+			v, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if k.ShortTag() == "!!merge" {
+				yamlMerge(m, v)
+				continue
+			}
+			if line, ok := lines[k.Value]; ok {
+				return nil, &DecodeError{Format: FormatYAML, Line: k.Line, Column: k.Column, Msg: fmt.Sprintf("key %q already defined at line %d", k.Value, line)}
+			}
+			lines[k.Value] = k.Line
+			m[k.Value] = v
+		}
+		return m, nil
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			if err := n.Decode(&b); err != nil {
+				return nil, &DecodeError{Format: FormatYAML, Line: n.Line, Column: n.Column, Msg: err.Error()}
+			}
+			return b, nil
+		case "!!int", "!!float":
+			if reJSONNumber.MatchString(n.Value) {
+				return json.Number(n.Value), nil
+			}
+			// e.g. 0x1F or 1_000
+			var v interface{}
+			if err := n.Decode(&v); err == nil {
+				if i, ok := v.(int); ok {
+					return json.Number(strconv.Itoa(i)), nil
+				}
+			}
+		}
+		return n.Value, nil
+	}
+	return nil, &DecodeError{Format: FormatYAML, Line: n.Line, Column: n.Column, Msg: "unsupported YAML node"}
+}
+
+// yamlMerge applies a "<<" merge key, keys already set win.
+func yamlMerge(m map[string]interface{}, v interface{}) {
+	sources := []interface{}{v}
+	if list, ok := v.([]interface{}); ok {
+		sources = list
+	}
+	for _, src := range sources {
+		if sm, ok := src.(map[string]interface{}); ok {
+			for k, item := range sm {
+				if _, exists := m[k]; !exists {
+					m[k] = item
+				}
+			}
+		}
+	}
+}
+
+// decodeTOML decodes a TOML document. Dates and times become the
+// strings they were written as and numbers become json.Number.
+func decodeTOML(src []byte) (interface{}, error) {
+	data := map[string]interface{}{}
+	if _, err := toml.Decode(string(src), &data); err != nil {
+		derr := &DecodeError{Format: FormatTOML, Msg: strings.TrimPrefix(err.Error(), "toml: ")}
+		if m := reErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			derr.Msg = m[2]
+		}
+		if pe, ok := err.(toml.ParseError); ok {
+			derr.Line, derr.Column = lineColumn(src, int64(pe.Position.Start))
+			derr.Column++
+		}
+		return nil, derr
+	}
+	return tomlValue(data), nil
+}
+
+// tomlValue converts decoded TOML to the values produced by decoding
+// JSON.
+func tomlValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			m[k] = tomlValue(item)
+		}
+		return m
+	case []map[string]interface{}:
+		list := make([]interface{}, len(x))
+		for i, item := range x {
+			list[i] = tomlValue(item)
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i, item := range x {
+			list[i] = tomlValue(item)
+		}
+		return list
+	case int64:
+		return json.Number(strconv.FormatInt(x, 10))
+	case float64:
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return strconv.FormatFloat(x, 'f', -1, 64)
+		}
+		return json.Number(strconv.FormatFloat(x, 'f', -1, 64))
+	case time.Time:
+		// The TOML library marks local dates and times by location.
+		switch x.Location().String() {
+		case "date-local":
+			return x.Format("2006-01-02")
+		case "time-local":
+			return x.Format("15:04:05.999999999")
+		case "datetime-local":
+			return x.Format("2006-01-02T15:04:05.999999999")
+		}
+		return x.Format(time.RFC3339Nano)
+	}
+	return v
+}
@@ -0,0 +1,104 @@
+// engine.go selects between running Pandoc and the native Go template
+// engine.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Engine identifies what renders a template.
+type Engine int
+
+const (
+	// EnginePandoc runs Pandoc, this is the default.
+	EnginePandoc Engine = iota
+
+	// EngineNative fills in the template in Go without running
+	// Pandoc. It does not support Pandoc options so it can't
+	// convert Markdown or change the output format.
+	EngineNative
+
+	// EngineAuto uses the native engine when a template is given
+	// and no Pandoc options are, otherwise Pandoc.
+	EngineAuto
+)
+
+var engineNames = map[Engine]string{
+	EnginePandoc: "pandoc",
+	EngineNative: "native",
+	EngineAuto:   "auto",
+}
+
+// String returns the engine name as accepted by ParseEngine.
+func (e Engine) String() string {
+	if name, ok := engineNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("engine(%d)", int(e))
+}
+
+// ParseEngine maps a name ("pandoc", "native" or "auto") to an Engine.
+func ParseEngine(name string) (Engine, error) {
+	for e, s := range engineNames {
+		if strings.EqualFold(name, s) {
+			return e, nil
+		}
+	}
+	return EnginePandoc, fmt.Errorf("unknown engine %q", name)
+}
+
+// useNative reports if the Renderer should use the native engine for
+// this template and options.
+func (r *Renderer) useNative(template string, options []string) bool {
+	switch r.Engine {
+	case EngineNative:
+		return true
+	case EngineAuto:
+		return template != "" && len(r.Options) == 0 && len(options) == 0
+	}
+	return false
+}
+
+// renderNative fills in the template in Go.
+func (r *Renderer) renderNative(data map[string]interface{}, template string, options []string) ([]byte, error) {
+	if template == "" {
+		return nil, fmt.Errorf("the native engine requires a template")
+	}
+	if opts := append(append([]string{}, r.Options...), options...); len(opts) > 0 {
+		return nil, fmt.Errorf("the native engine does not support Pandoc options %q", strings.Join(opts, " "))
+	}
+	name := template
+	if r.Dir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(r.Dir, name)
+	}
+	if r.Logger != nil {
+		r.Logger.Printf("native %s", name)
+	}
+	tmpl, err := ReadTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	// Pandoc's HTML writer derives pagetitle from title. The caller's
+	// map may be shared with other renders so a copy gets it.
+	if _, ok := data["pagetitle"]; !ok {
+		if title, ok := data["title"].(string); ok {
+			m := make(map[string]interface{}, len(data)+1)
+			for k, v := range data {
+				m[k] = v
+			}
+			m["pagetitle"] = title
+			data = m
+		}
+	}
+	return tmpl.Execute(data)
+}
@@ -0,0 +1,87 @@
+// engine_test.go checks the native engine against Pandoc's output.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestNativeMatchesPandoc renders the repository's own codemeta
+// templates with the native engine and compares them to the files
+// Pandoc rendered, see the Makefile's about.md and CITATION.cff rules.
+func TestNativeMatchesPandoc(t *testing.T) {
+	src, err := ioutil.ReadFile("codemeta.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		template string
+		expected string
+		metadata map[string]interface{}
+	}{
+		{"codemeta-about.tmpl", "about.md", nil},
+		{"codemeta-cff.tmpl", "CITATION.cff", map[string]interface{}{"title": "Cite pdtmpl"}},
+	} {
+		data := map[string]interface{}{}
+		if err := json.Unmarshal(src, &data); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tc.metadata {
+			data[k] = v
+		}
+		// The Makefile renames the "@" keys as DefaultKeyMapping does.
+		r := &Renderer{Engine: EngineNative, KeyMapping: DefaultKeyMapping}
+		out, err := r.RenderData(context.Background(), data, tc.template, nil)
+		if err != nil {
+			t.Errorf("%s, %s", tc.template, err)
+			continue
+		}
+		expected, err := ioutil.ReadFile(tc.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, out) {
+			t.Errorf("%s, expected %s\n%s\ngot\n%s", tc.template, tc.expected, expected, out)
+		}
+	}
+}
+
+// TestNativeLeavesDataAlone checks the derived pagetitle isn't written
+// into the caller's data, which may be shared by concurrent renders.
+func TestNativeLeavesDataAlone(t *testing.T) {
+	template := filepath.Join(t.TempDir(), "page.tmpl")
+	if err := os.WriteFile(template, []byte("$pagetitle$\n"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"title": "Shared"}
+	r := &Renderer{Engine: EngineNative}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := r.RenderData(context.Background(), data, template, nil)
+			if err != nil || string(out) != "Shared\n" {
+				t.Errorf("expected %q, got %q, %v", "Shared\n", out, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := data["pagetitle"]; ok {
+		t.Errorf("pagetitle was added to the caller's data, %v", data)
+	}
+}
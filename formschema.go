@@ -0,0 +1,300 @@
+// formschema.go turns webform definitions into JSON Schema documents so
+// a server can validate submissions against the same form.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// JSONSchemaDialect is the version of JSON Schema FormToJSONSchema
+// writes.
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// inputFormats are the JSON Schema formats of input types.
+var inputFormats = map[string]string{
+	"email": "email",
+	"url":   "uri",
+	"date":  "date",
+}
+
+// inputPatterns are the patterns of input types without a format. A
+// time input sends "14:30" which JSON Schema's "time" format, needing
+// seconds and an offset, refuses.
+var inputPatterns = map[string]string{
+	"datetime-local": `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(:\d{2}(\.\d+)?)?$`,
+	"month":          `^\d{4}-\d{2}$`,
+	"week":           `^\d{4}-W\d{2}$`,
+	"color":          `^#[0-9a-fA-F]{6}$`,
+	"time":           `^\d{2}:\d{2}(:\d{2}(\.\d+)?)?$`,
+}
+
+// unsubmittedTypes are the element types which aren't validated, they
+// send no value or, like a file, aren't sent as text.
+var unsubmittedTypes = map[string]bool{
+	"button":   true,
+	"datalist": true,
+	"file":     true,
+	"image":    true,
+	"output":   true,
+	"reset":    true,
+	"submit":   true,
+}
+
+// FormToJSONSchema turns a form object, as read by ApplyWebForm, into a
+// JSON Schema document describing its submissions. Each control with a
+// "name" is a property, a control without one isn't submitted. The
+// "required", "pattern", "minlength", "maxlength", "min" and "max"
+// attributes and the input type become constraints, options become an
+// enum and a multiple select or checkbox group an array. Disabled
+// controls and buttons are left out. Number and range inputs are
+// expected to be decoded as numbers, everything else as strings. Only
+// lone radio buttons may share a name, another repeated name is an
+// error. The form is checked as MkWebForm would check it.
+//
+//```
+//  schema, err := pdtmpl.FormToJSONSchema(form)
+//  if err != nil {
+//      // ... handle error
+//  }
+//  src, _ := json.MarshalIndent(schema, "", "    ")
+//```
+//
+func FormToJSONSchema(form map[string]interface{}) (map[string]interface{}, error) {
+	if err := MkWebForm(ioutil.Discard, ioutil.Discard, form); err != nil {
+		return nil, err
+	}
+	schema := map[string]interface{}{
+		"$schema": JSONSchemaDialect,
+		"type":    "object",
+	}
+	if title, ok := form["title"].(string); ok {
+		schema["title"] = title
+	} else if id, ok := form["id"].(string); ok {
+		schema["title"] = id
+	}
+	properties := map[string]interface{}{}
+	required := []string{}
+	if elements, ok := form["elements"].([]interface{}); ok {
+		if err := formProperties(elements, properties, &required, map[string]bool{}); err != nil {
+			return nil, err
+		}
+	}
+	schema["properties"] = properties
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+// formProperties adds the properties of the submitted controls in
+// elements, and the names of those required. Radios notes the names of
+// lone radio buttons, the only controls which may share a name.
+func formProperties(elements []interface{}, properties map[string]interface{}, required *[]string, radios map[string]bool) error {
+	for _, item := range elements {
+		elem, _ := item.(map[string]interface{})
+		if disabled, _ := elem["disabled"].(bool); disabled {
+			continue
+		}
+		eType, ok := elem["type"].(string)
+		if !ok {
+			eType = "text"
+		}
+		if eType == "fieldset" {
+			if l, ok := elem["elements"].([]interface{}); ok {
+				if err := formProperties(l, properties, required, radios); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		name, _ := elem["name"].(string)
+		if unsubmittedTypes[eType] || name == "" {
+			continue
+		}
+		prop := formProperty(elem, eType)
+		lone := eType == "radio" && elem["options"] == nil
+		if old, ok := properties[name].(map[string]interface{}); ok {
+			// Radio buttons sharing a name are one choice, any other
+			// repeat would replace the earlier control's rules.
+			if !lone || !radios[name] {
+				return fmt.Errorf("control name %q is repeated", name)
+			}
+			prop["enum"] = append(old["enum"].([]string), prop["enum"].([]string)...)
+		}
+		radios[name] = lone
+		properties[name] = prop
+		if isRequired, _ := elem["required"].(bool); isRequired && !matchAnyString(name, *required) {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// formProperty describes the values a control submits.
+func formProperty(elem map[string]interface{}, eType string) map[string]interface{} {
+	prop := map[string]interface{}{}
+	if label, ok := elem["label"].(string); ok {
+		prop["title"] = label
+	}
+	description := []string{}
+	for _, k := range []string{"hint", "help"} {
+		if s, ok := elem[k].(string); ok {
+			description = append(description, s)
+		}
+	}
+	if len(description) > 0 {
+		prop["description"] = strings.Join(description, " ")
+	}
+	isRequired, _ := elem["required"].(bool)
+	switch eType {
+	case "number", "range":
+		prop["type"] = "number"
+		if eType == "range" {
+			// HTML's defaults
+			prop["minimum"], prop["maximum"] = 0.0, 100.0
+		}
+		if min, ok := formNumber(elem["min"]); ok {
+			prop["minimum"] = min
+		}
+		if max, ok := formNumber(elem["max"]); ok {
+			prop["maximum"] = max
+		}
+		return prop
+	case "radio", "checkbox", "select":
+		if options, ok := elem["options"]; ok {
+			values := optionValues(formOptions(options), []string{})
+			multiple, _ := elem["multiple"].(bool)
+			if eType == "checkbox" || (eType == "select" && multiple) {
+				prop["type"] = "array"
+				prop["items"] = map[string]interface{}{"type": "string", "enum": values}
+				prop["uniqueItems"] = true
+				if isRequired {
+					prop["minItems"] = 1
+				}
+			} else {
+				prop["type"] = "string"
+				prop["enum"] = values
+			}
+			return prop
+		}
+		if eType != "select" {
+			// A lone checkbox or radio button sends its value, "on"
+			// by default.
+			value := "on"
+			if v, ok := elem["value"]; ok {
+				value = fmt.Sprintf("%v", v)
+			}
+			prop["type"] = "string"
+			prop["enum"] = []string{value}
+			return prop
+		}
+	}
+	prop["type"] = "string"
+	if format, ok := inputFormats[eType]; ok {
+		prop["format"] = format
+	}
+	if pattern, ok := inputPatterns[eType]; ok {
+		prop["pattern"] = pattern
+	}
+	if pattern, ok := elem["pattern"].(string); ok {
+		// An HTML pattern matches the whole value.
+		prop["pattern"] = "^(?:" + pattern + ")$"
+	}
+	if n, ok := formNumber(elem["minlength"]); ok {
+		prop["minLength"] = int(n)
+	}
+	if n, ok := formNumber(elem["maxlength"]); ok {
+		prop["maxLength"] = int(n)
+	}
+	if isRequired {
+		// A required control can't be left empty.
+		if n, ok := prop["minLength"].(int); !ok || n < 1 {
+			prop["minLength"] = 1
+		}
+	}
+	return prop
+}
+
+// optionValues lists the values which may be chosen, leaving out
+// disabled options and groups.
+func optionValues(options []formOption, values []string) []string {
+	for _, option := range options {
+		switch {
+		case option.Disabled:
+		case option.Options != nil:
+			values = optionValues(option.Options, values)
+		default:
+			values = append(values, option.Value)
+		}
+	}
+	return values
+}
+
+// formNumber reads a number given in YAML as a number or as text.
+func formNumber(val interface{}) (float64, bool) {
+	switch val := val.(type) {
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case float64:
+		return val, true
+	case string:
+		if n, err := strconv.ParseFloat(val, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// ApplyFormSchema reads Markdown as ApplyWebForm does and writes a JSON
+// object mapping the "id" of each form found, or its "name" when it has
+// no id, to its JSON Schema, see FormToJSONSchema.
+//
+//```
+//  if err := pdtmpl.ApplyFormSchema(os.Stdin, os.Stdout, os.Stderr); err != nil {
+//      // ... handle error
+//  }
+//```
+//
+func ApplyFormSchema(in io.Reader, out io.Writer, eout io.Writer) error {
+	schemas := map[string]interface{}{}
+	err := scanWebForms(in, ioutil.Discard, eout, func(form map[string]interface{}) error {
+		key, _ := form["id"].(string)
+		if key == "" {
+			key, _ = form["name"].(string)
+		}
+		if key == "" {
+			return fmt.Errorf("form has no id or name")
+		}
+		if _, ok := schemas[key]; ok {
+			return fmt.Errorf("form %q is repeated", key)
+		}
+		schema, err := FormToJSONSchema(form)
+		if err != nil {
+			return err
+		}
+		schemas[key] = schema
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(schemas)
+}
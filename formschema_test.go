@@ -0,0 +1,75 @@
+// formschema_test.go checks the JSON Schema made from webforms.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	// 3rd Party libraries
+	"gopkg.in/yaml.v3"
+)
+
+// formSchema decodes a YAML form and returns its schema's properties.
+func formSchema(t *testing.T, src string) (map[string]interface{}, error) {
+	t.Helper()
+	form := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(src), &form); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := FormToJSONSchema(form)
+	if err != nil {
+		return nil, err
+	}
+	return schema["properties"].(map[string]interface{}), nil
+}
+
+func TestFormToJSONSchemaTime(t *testing.T) {
+	properties, err := formSchema(t, "elements: [ { name: at, type: time } ]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop := properties["at"].(map[string]interface{})
+	if _, ok := prop["format"]; ok {
+		t.Errorf("time should have no format, got %v", prop)
+	}
+	re := regexp.MustCompile(prop["pattern"].(string))
+	for _, value := range []string{"14:30", "14:30:05", "14:30:05.25"} {
+		if !re.MatchString(value) {
+			t.Errorf("%q should match %s", value, re)
+		}
+	}
+	if re.MatchString("2pm") {
+		t.Errorf("%q should not match %s", "2pm", re)
+	}
+}
+
+func TestFormToJSONSchemaRepeatedNames(t *testing.T) {
+	properties, err := formSchema(t, `elements:
+  - { type: radio, name: pay, value: card }
+  - { type: radio, name: pay, value: cash }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := properties["pay"].(map[string]interface{})["enum"]; strings.Join(values.([]string), ",") != "card,cash" {
+		t.Errorf("expected the radio values card and cash, got %v", values)
+	}
+	for _, src := range []string{
+		"elements: [ { type: hidden, name: q }, { name: q } ]",
+		"elements: [ { type: select, name: s, options: [ a ] }, { type: fieldset, elements: [ { type: select, name: s, options: [ b ] } ] } ]",
+		"elements: [ { type: radio, name: r }, { type: radio, name: r, options: [ a ] } ]",
+	} {
+		if _, err := formSchema(t, src); err == nil || !strings.Contains(err.Error(), "is repeated") {
+			t.Errorf("%s, expected a repeated name error, got %v", src, err)
+		}
+	}
+}
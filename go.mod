@@ -2,4 +2,7 @@ module github.com/rsdoiel/pdtmpl
 
 go 1.18
 
-require gopkg.in/yaml.v3 v3.0.1 // indirect
+require (
+	github.com/BurntSushi/toml v1.2.1
+	gopkg.in/yaml.v3 v3.0.1
+)
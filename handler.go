@@ -0,0 +1,369 @@
+// handler.go is an HTTP service rendering documents posted to it with
+// the templates of an allow-listed directory.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes is the largest document a Handler accepts.
+	DefaultMaxBytes = 1 << 20
+
+	// DefaultRequestTimeout bounds how long a Handler spends on a
+	// request when the Renderer has no Timeout.
+	DefaultRequestTimeout = 30 * time.Second
+)
+
+// formatContentTypes maps the Pandoc output formats a Handler will
+// produce to their content type.
+var formatContentTypes = map[string]string{
+	"html":              "text/html; charset=utf-8",
+	"html4":             "text/html; charset=utf-8",
+	"html5":             "text/html; charset=utf-8",
+	"revealjs":          "text/html; charset=utf-8",
+	"slidy":             "text/html; charset=utf-8",
+	"slideous":          "text/html; charset=utf-8",
+	"dzslides":          "text/html; charset=utf-8",
+	"s5":                "text/html; charset=utf-8",
+	"markdown":          "text/markdown; charset=utf-8",
+	"markdown_strict":   "text/markdown; charset=utf-8",
+	"markdown_phpextra": "text/markdown; charset=utf-8",
+	"markdown_mmd":      "text/markdown; charset=utf-8",
+	"gfm":               "text/markdown; charset=utf-8",
+	"commonmark":        "text/markdown; charset=utf-8",
+	"commonmark_x":      "text/markdown; charset=utf-8",
+	"plain":             "text/plain; charset=utf-8",
+	"native":            "text/plain; charset=utf-8",
+	"asciidoc":          "text/plain; charset=utf-8",
+	"org":               "text/plain; charset=utf-8",
+	"mediawiki":         "text/plain; charset=utf-8",
+	"dokuwiki":          "text/plain; charset=utf-8",
+	"textile":           "text/plain; charset=utf-8",
+	"jira":              "text/plain; charset=utf-8",
+	"man":               "text/plain; charset=utf-8",
+	"ms":                "text/plain; charset=utf-8",
+	"texinfo":           "text/plain; charset=utf-8",
+	"rst":               "text/x-rst; charset=utf-8",
+	"latex":             "application/x-latex; charset=utf-8",
+	"beamer":            "application/x-latex; charset=utf-8",
+	"context":           "text/plain; charset=utf-8",
+	"json":              "application/json",
+	"csljson":           "application/json",
+	"docbook":           "application/xml; charset=utf-8",
+	"docbook4":          "application/xml; charset=utf-8",
+	"docbook5":          "application/xml; charset=utf-8",
+	"jats":              "application/xml; charset=utf-8",
+	"tei":               "application/xml; charset=utf-8",
+	"opml":              "application/xml; charset=utf-8",
+	"icml":              "application/xml; charset=utf-8",
+	"rtf":               "application/rtf",
+	"bibtex":            "application/x-bibtex; charset=utf-8",
+	"biblatex":          "application/x-bibtex; charset=utf-8",
+}
+
+// HandlerOptions are the Pandoc options a Handler accepts unless its
+// Renderer has a Policy. None of them read or write files.
+var HandlerOptions = []string{
+	"--to",
+	"--standalone",
+	"--toc",
+	"--table-of-contents",
+	"--toc-depth",
+	"--number-sections",
+	"--number-offset",
+	"--section-divs",
+	"--html-q-tags",
+	"--ascii",
+	"--wrap",
+	"--columns",
+	"--eol",
+	"--variable",
+	"--metadata",
+	"--shift-heading-level-by",
+	"--id-prefix",
+	"--title-prefix",
+	"--strip-comments",
+	"--no-highlight",
+	"--top-level-division",
+	"--reference-links",
+	"--reference-location",
+	"--tab-stop",
+	"--preserve-tabs",
+	"--markdown-headings",
+	"--email-obfuscation",
+}
+
+// Handler is an http.Handler rendering the JSON, YAML or TOML document
+// POSTed to it. The "template" query parameter names a template in
+// TemplateDir, the other query parameters are Pandoc options, e.g.
+// "?template=page.tmpl&to=html5&toc&variable=lang:en" runs
+// "pandoc --template TemplateDir/page.tmpl --to=html5 --toc
+// --variable=lang:en". The options are checked by the Renderer's
+// Policy, when it has none only the HandlerOptions are accepted and
+// Pandoc runs with "--sandbox". The document's format comes from the
+// Content-Type, "application/json", "application/yaml" or
+// "application/toml", and is guessed otherwise. The response's
+// Content-Type follows the "to" format (default HTML).
+//
+// Errors are reported as plain text with a status of 400 (bad
+// document or option), 403 (template outside TemplateDir), 404
+// (template not found), 405 (not a POST), 413 (document larger than
+// MaxBytes), 415 (unsupported Content-Type), 504 (render took longer
+// than the timeout) or 500.
+//
+//```
+//  r, _ := pdtmpl.NewRenderer()
+//  h := pdtmpl.NewHandler(r)
+//  h.TemplateDir = "/usr/local/share/templates"
+//  http.ListenAndServe(":8000", h)
+//```
+//
+// and a client
+//
+//```
+//  curl --data-binary @page.json -H "Content-Type: application/json" \
+//       "http://localhost:8000/?template=page.tmpl&to=html5"
+//```
+//
+type Handler struct {
+	// TemplateDir is the directory templates are loaded from, a
+	// request naming a template when TemplateDir is empty is refused
+	TemplateDir string
+
+	// MaxBytes is the largest document accepted, DefaultMaxBytes
+	// when zero
+	MaxBytes int64
+
+	// Timeout bounds each request, the Renderer's Timeout or
+	// DefaultRequestTimeout when zero
+	Timeout time.Duration
+
+	r *Renderer
+}
+
+// NewHandler returns a Handler rendering with a copy of r. Set its
+// TemplateDir before use.
+func NewHandler(r *Renderer) *Handler {
+	rh := *r
+	if rh.Policy == nil {
+		rh.Policy = &OptionPolicy{Allow: HandlerOptions}
+	}
+	return &Handler{r: &rh}
+}
+
+// httpError is an error with the HTTP status to report it with.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+// statusError builds an httpError.
+func statusError(status int, format string, args ...interface{}) error {
+	return &httpError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	out, contentType, err := h.render(req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var (
+			he *httpError
+			ie *InterruptedError
+			de *DecodeError
+			oe *OptionError
+		)
+		switch {
+		case errors.As(err, &he):
+			status = he.status
+		case errors.As(err, &ie):
+			status = http.StatusGatewayTimeout
+		case errors.As(err, &de), errors.As(err, &oe):
+			status = http.StatusBadRequest
+		}
+		if h.r.Logger != nil {
+			h.r.Logger.Printf("%s %s, %d %s", req.Method, req.URL, status, err)
+		}
+		if status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", http.MethodPost)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out)
+}
+
+// render handles a request returning the rendered document and its
+// content type.
+func (h *Handler) render(req *http.Request) ([]byte, string, error) {
+	if req.Method != http.MethodPost {
+		return nil, "", statusError(http.StatusMethodNotAllowed, "expected a POST")
+	}
+	format, err := requestFormat(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", err
+	}
+	query := req.URL.Query()
+	template := ""
+	if name := query.Get("template"); name != "" {
+		if template, err = h.templatePath(name); err != nil {
+			return nil, "", err
+		}
+	}
+	query.Del("template")
+	options, to, err := queryOptions(query)
+	if err != nil {
+		return nil, "", err
+	}
+	maxBytes := h.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	src, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", statusError(http.StatusBadRequest, "%s", err)
+	}
+	if int64(len(src)) > maxBytes {
+		return nil, "", statusError(http.StatusRequestEntityTooLarge, "document larger than %d bytes", maxBytes)
+	}
+	data, err := DecodeDocument(src, format)
+	if err != nil {
+		return nil, "", err
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = h.r.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	out, err := h.r.RenderData(ctx, data, template, options)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, formatContentTypes[to], nil
+}
+
+// templatePath resolves a template name inside TemplateDir refusing
+// names which lead outside it, including by a symbolic link.
+func (h *Handler) templatePath(name string) (string, error) {
+	if h.TemplateDir == "" {
+		return "", statusError(http.StatusForbidden, "templates are not enabled")
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return "", statusError(http.StatusForbidden, "template %q must be a name in the template directory", name)
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", statusError(http.StatusForbidden, "template %q is outside the template directory", name)
+	}
+	dir, err := filepath.Abs(h.TemplateDir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+	fName, err := filepath.EvalSymlinks(filepath.Join(dir, clean))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", statusError(http.StatusNotFound, "template %q not found", name)
+		}
+		return "", err
+	}
+	if !strings.HasPrefix(fName, dir+string(filepath.Separator)) {
+		return "", statusError(http.StatusForbidden, "template %q is outside the template directory", name)
+	}
+	if info, err := os.Stat(fName); err != nil || info.IsDir() {
+		return "", statusError(http.StatusNotFound, "template %q not found", name)
+	}
+	return fName, nil
+}
+
+// requestFormat maps a Content-Type to a document format.
+func requestFormat(contentType string) (string, error) {
+	if contentType == "" {
+		return FormatAuto, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", statusError(http.StatusUnsupportedMediaType, "bad Content-Type %q", contentType)
+	}
+	switch mediaType {
+	case "application/json", "text/json":
+		return FormatJSON, nil
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML, nil
+	case "application/toml", "text/toml":
+		return FormatTOML, nil
+	case "text/plain", "application/octet-stream", "application/x-www-form-urlencoded":
+		// curl --data sends form encoding unless told otherwise
+		return FormatAuto, nil
+	}
+	return "", statusError(http.StatusUnsupportedMediaType, "expected JSON, YAML or TOML, found %q", mediaType)
+}
+
+// queryOptions turns query parameters into Pandoc options, it returns
+// the output format too.
+func queryOptions(query map[string][]string) ([]string, string, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	to := "html"
+	options := []string{}
+	for _, name := range names {
+		// The Renderer's Policy decides which options are allowed.
+		hasValue := takesValue("--" + name)
+		for _, value := range query[name] {
+			switch {
+			case hasValue && value == "":
+				return nil, "", statusError(http.StatusBadRequest, "option %q needs a value", name)
+			case value == "false" && !hasValue:
+			case value == "" || (value == "true" && !hasValue):
+				options = append(options, "--"+name)
+			default:
+				options = append(options, "--"+name+"="+value)
+			}
+		}
+	}
+	if values, ok := query["to"]; ok {
+		to = values[len(values)-1]
+		// Drop extensions, e.g. "markdown+smart".
+		if i := strings.IndexAny(to, "+-"); i > 0 {
+			to = to[:i]
+		}
+		if _, ok := formatContentTypes[to]; !ok {
+			return nil, "", statusError(http.StatusBadRequest, "output format %q is not supported", to)
+		}
+	}
+	return options, to, nil
+}
@@ -0,0 +1,410 @@
+// lint.go checks Pandoc templates for mistakes before they are
+// rendered.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// SeverityError marks a diagnostic Pandoc will fail on
+	SeverityError = "error"
+
+	// SeverityWarning marks a diagnostic which is legal but likely
+	// a mistake
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a problem found in a template.
+type Diagnostic struct {
+	// File is the template or partial holding the problem, "-" when
+	// read from an io.Reader
+	File string `json:"file"`
+
+	// Line and Column of the problem, starting at 1
+	Line   int `json:"line"`
+	Column int `json:"column"`
+
+	// Severity is SeverityError or SeverityWarning
+	Severity string `json:"severity"`
+
+	// Kind classifies the problems found by CheckTemplate, it is
+	// KindMissing, KindUnused or KindType
+	Kind string `json:"kind,omitempty"`
+
+	// Msg describes the problem
+	Msg string `json:"message"`
+}
+
+// String formats the diagnostic as "file:line:column: severity: message",
+// the file and position are left out when unknown.
+func (d Diagnostic) String() string {
+	switch {
+	case d.File == "":
+		return fmt.Sprintf("%s: %s", d.Severity, d.Msg)
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s: %s", d.File, d.Severity, d.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Msg)
+}
+
+// HasErrors reports if any of the diagnostics is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// LintTemplate reads a Pandoc template and reports balanced
+// conditionals and loops, mixed `$...$` and `${...}` delimiters within
+// a block, unknown pipes, unclosed `$` and partials that can't be found.
+// Partials are found relative to the working directory, use LintFile
+// to find them relative to the template.
+//
+//```
+//  diagnostics := pdtmpl.LintTemplate(os.Stdin)
+//  for _, d := range diagnostics {
+//      fmt.Println(d)
+//  }
+//  if pdtmpl.HasErrors(diagnostics) {
+//      os.Exit(1)
+//  }
+//```
+//
+func LintTemplate(r io.Reader) []Diagnostic {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return []Diagnostic{{File: "-", Severity: SeverityError, Msg: err.Error()}}
+	}
+	return lintSource("-", src)
+}
+
+// LintFile lints a template file, see LintTemplate.
+func LintFile(name string) []Diagnostic {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return []Diagnostic{{File: name, Severity: SeverityError, Msg: err.Error()}}
+	}
+	return lintSource(name, src)
+}
+
+var (
+	reBlockKeyword = regexp.MustCompile(`^(if|elseif|for)\s*\(\s*(.*?)\s*\)$`)
+	reBareKeyword  = regexp.MustCompile(`^(else|endif|sep|endfor)$`)
+)
+
+// lintBlock is an if or for waiting for its end.
+type lintBlock struct {
+	word   string
+	text   string
+	pos    position
+	closer string
+	els    bool
+	sep    bool
+}
+
+// linter scans a template directive by directive so it can report
+// more than the first problem.
+type linter struct {
+	name        string
+	src         string
+	offset      int
+	line        int
+	col         int
+	blocks      []*lintBlock
+	diagnostics []Diagnostic
+}
+
+func lintSource(name string, src []byte) []Diagnostic {
+	l := &linter{name: name, src: string(src), line: 1, col: 1}
+	l.scan()
+	// Anything the native parser rejects that the scan missed is
+	// still reported.
+	if !HasErrors(l.diagnostics) {
+		if _, err := ParseTemplate(name, src); err != nil {
+			l.addError(err)
+		}
+	}
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		if a.File != b.File {
+			return a.File == name
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diagnostics
+}
+
+func (l *linter) report(severity string, pos position, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{
+		File:     l.name,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Severity: severity,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// addError reports an error from the template parser.
+func (l *linter) addError(err error) {
+	if pe, ok := err.(*ParseError); ok {
+		l.diagnostics = append(l.diagnostics, Diagnostic{
+			File:     pe.Name,
+			Line:     pe.Line,
+			Column:   pe.Column,
+			Severity: SeverityError,
+			Msg:      pe.Msg,
+		})
+		return
+	}
+	l.diagnostics = append(l.diagnostics, Diagnostic{File: l.name, Severity: SeverityError, Msg: err.Error()})
+}
+
+func (l *linter) advance(n int) {
+	for i := 0; i < n && l.offset < len(l.src); i++ {
+		c := l.src[l.offset]
+		l.offset++
+		if c == '\n' {
+			l.line++
+			l.col = 1
+		} else if c < 0x80 || c >= 0xC0 {
+			l.col++
+		}
+	}
+}
+
+// directiveEnd finds the closing delimiter of a directive starting at
+// offset, skipping quoted pipe arguments and separators. It returns -1
+// when the line or template ends first.
+func (l *linter) directiveEnd(offset int, closer byte) int {
+	for i := offset; i < len(l.src); i++ {
+		switch c := l.src[i]; c {
+		case '\n', '\r':
+			return -1
+		case closer:
+			return i
+		case '"', '[':
+			end := byte('"')
+			if c == '[' {
+				end = ']'
+			}
+			for i++; i < len(l.src) && l.src[i] != end && l.src[i] != '\n'; i++ {
+				if c == '"' && l.src[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// scan walks the template checking each directive.
+func (l *linter) scan() {
+	for l.offset < len(l.src) {
+		rest := l.src[l.offset:]
+		switch {
+		case strings.HasPrefix(rest, "$$"):
+			l.advance(2)
+		case strings.HasPrefix(rest, "$--"):
+			for l.offset < len(l.src) && l.src[l.offset] != '\n' {
+				l.advance(1)
+			}
+		case strings.HasPrefix(rest, "$"):
+			pos := position{l.line, l.col}
+			opener, closer := "$", byte('$')
+			if strings.HasPrefix(rest, "${") {
+				opener, closer = "${", '}'
+			}
+			start := l.offset + len(opener)
+			end := l.directiveEnd(start, closer)
+			if end < 0 {
+				l.report(SeverityError, pos, "unclosed %q", opener)
+				l.advance(len(opener))
+				continue
+			}
+			l.advance(len(opener))
+			contentPos := position{l.line, l.col}
+			l.directive(pos, contentPos, opener, l.src[start:end])
+			l.advance(end + 1 - start)
+		default:
+			l.advance(1)
+		}
+	}
+	for _, b := range l.blocks {
+		l.report(SeverityError, b.pos, "%s without matching end%s", b.text, b.word)
+	}
+}
+
+// directive checks the content of a single directive.
+func (l *linter) directive(pos position, contentPos position, opener string, content string) {
+	trimmed := strings.TrimSpace(content)
+	// account for leading spaces in positions
+	contentPos.Column += len(content) - len(strings.TrimLeft(content, " \t"))
+	if trimmed == "~" || trimmed == "^" {
+		return
+	}
+	display := opener + content + "$"
+	if opener == "${" {
+		display = opener + content + "}"
+	}
+	if loc := reBlockKeyword.FindStringSubmatchIndex(trimmed); loc != nil {
+		word, name := trimmed[loc[2]:loc[3]], trimmed[loc[4]:loc[5]]
+		varPos := contentPos
+		varPos.Column += loc[4]
+		l.variable(varPos, name, false)
+		switch word {
+		case "if", "for":
+			l.blocks = append(l.blocks, &lintBlock{word: word, text: display, pos: pos, closer: opener})
+		case "elseif":
+			if b := l.inBlock(pos, "if", display); b != nil {
+				if b.els {
+					l.report(SeverityError, pos, "%s after else", display)
+				}
+				l.checkDelimiters(pos, b, opener, display)
+			}
+		}
+		return
+	}
+	if m := reBareKeyword.FindStringSubmatch(trimmed); m != nil {
+		switch m[1] {
+		case "else":
+			if b := l.inBlock(pos, "if", display); b != nil {
+				if b.els {
+					l.report(SeverityError, pos, "more than one else for %s", b.text)
+				}
+				b.els = true
+				l.checkDelimiters(pos, b, opener, display)
+			}
+		case "sep":
+			if b := l.inBlock(pos, "for", display); b != nil {
+				if b.sep {
+					l.report(SeverityError, pos, "more than one sep for %s", b.text)
+				}
+				b.sep = true
+				l.checkDelimiters(pos, b, opener, display)
+			}
+		case "endif", "endfor":
+			l.endBlock(pos, strings.TrimPrefix(m[1], "end"), opener, display)
+		}
+		return
+	}
+	l.variable(contentPos, content[len(content)-len(strings.TrimLeft(content, " \t")):], true)
+}
+
+// inBlock checks that the innermost open block is word.
+func (l *linter) inBlock(pos position, word string, display string) *lintBlock {
+	if n := len(l.blocks); n > 0 && l.blocks[n-1].word == word {
+		return l.blocks[n-1]
+	}
+	if n := len(l.blocks); n > 0 {
+		b := l.blocks[n-1]
+		l.report(SeverityError, pos, "%s inside %s at %d:%d, expected end%s", display, b.text, b.pos.Line, b.pos.Column, b.word)
+	} else {
+		l.report(SeverityError, pos, "%s outside of %s", display, word)
+	}
+	return nil
+}
+
+// endBlock closes the innermost block reporting blocks closed by
+// the wrong keyword.
+func (l *linter) endBlock(pos position, word string, opener string, display string) {
+	for i := len(l.blocks) - 1; i >= 0; i-- {
+		b := l.blocks[i]
+		if b.word != word {
+			continue
+		}
+		for _, inner := range l.blocks[i+1:] {
+			l.report(SeverityError, inner.pos, "%s without matching end%s before %s at %d:%d", inner.text, inner.word, display, pos.Line, pos.Column)
+		}
+		l.checkDelimiters(pos, b, opener, display)
+		l.blocks = l.blocks[:i]
+		return
+	}
+	l.report(SeverityError, pos, "%s without matching %s", display, word)
+}
+
+// checkDelimiters warns about a block which mixes $...$ and ${...}.
+// Pandoc accepts this, but the block is harder to read.
+func (l *linter) checkDelimiters(pos position, b *lintBlock, opener string, display string) {
+	if b.closer != opener {
+		l.report(SeverityWarning, pos, "%s uses different delimiters than %s at %d:%d", display, b.text, b.pos.Line, b.pos.Column)
+	}
+}
+
+// variable checks a variable, optionally followed by a partial,
+// separator and pipes, or a bare partial.
+func (l *linter) variable(pos position, content string, partials bool) {
+	p := newTemplateParser(l.name, strings.TrimRight(content, " \t"), 0)
+	p.line, p.col = pos.Line, pos.Column
+	start := p.save()
+	word := p.identifier()
+	if word == "" {
+		if p.eof() {
+			l.report(SeverityError, pos, "empty directive")
+		} else {
+			l.report(SeverityError, p.pos(), "unexpected %q in directive", p.peek())
+		}
+		return
+	}
+	var pipes []pipeCall
+	switch {
+	case partials && p.peek() == '(':
+		p.restore(start)
+		node, err := p.parsePartial(nil)
+		if err != nil {
+			l.addError(err)
+			return
+		}
+		pipes = node.(*partialNode).pipes
+	default:
+		p.restore(start)
+		v, err := p.parseVar()
+		if err != nil {
+			l.addError(err)
+			return
+		}
+		pipes = v.pipes
+		switch {
+		case partials && p.peek() == ':':
+			p.advance(1)
+			node, err := p.parsePartial(v)
+			if err != nil {
+				l.addError(err)
+				return
+			}
+			pipes = append(pipes, node.(*loopNode).body[0].(*partialNode).pipes...)
+		case partials && p.peek() == '[':
+			if _, err := p.parseSep(); err != nil {
+				l.addError(err)
+				return
+			}
+		}
+	}
+	if !p.eof() {
+		l.report(SeverityError, p.pos(), "unexpected %q in directive", p.peek())
+	}
+	for _, pc := range pipes {
+		if !HasPipe(pc.name) {
+			l.report(SeverityError, pc.pos, "unknown pipe %q", pc.name)
+		}
+	}
+}
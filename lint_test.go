@@ -0,0 +1,35 @@
+// lint_test.go checks the template linter.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintMixedDelimiters(t *testing.T) {
+	for _, src := range []string{
+		"$if(a)$x${endif}\n",
+		"${for(a)}$a$$endfor$\n",
+	} {
+		diagnostics := LintTemplate(strings.NewReader(src))
+		if len(diagnostics) != 1 || diagnostics[0].Severity != SeverityWarning {
+			t.Errorf("%q, expected a warning, got %v", src, diagnostics)
+		}
+	}
+	for _, src := range []string{
+		"$if(a)$x$endif$\n",
+		"${if(a)}x${endif}\n",
+	} {
+		if diagnostics := LintTemplate(strings.NewReader(src)); len(diagnostics) > 0 {
+			t.Errorf("%q, expected no diagnostics, got %v", src, diagnostics)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// manifest.go records what each output was rendered from so Walk and
+// RenderEach only rebuild the outputs whose inputs changed.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ManifestName is the usual name of a manifest, it is kept in the
+// output directory.
+const ManifestName = ".pdtmpl-cache.json"
+
+// manifestVersion changes when the manifest format does, an older
+// manifest is ignored.
+const manifestVersion = 1
+
+// Manifest records the inputs of each output. File names are relative
+// to the directory holding the manifest.
+type Manifest struct {
+	// Version of the manifest format
+	Version int `json:"version"`
+
+	// Outputs maps each output file to what it was rendered from
+	Outputs map[string]*ManifestEntry `json:"outputs"`
+
+	name string
+	mu   sync.Mutex
+}
+
+// ManifestEntry describes what an output was rendered from.
+type ManifestEntry struct {
+	// Source is the file rendered by Walk
+	Source string `json:"source,omitempty"`
+
+	// Batch is the output pattern of the RenderEach call which
+	// rendered the record
+	Batch string `json:"batch,omitempty"`
+
+	// Data is the SHA-256 of a record rendered by RenderEach
+	Data string `json:"data,omitempty"`
+
+	// Inputs maps the files read, the source, template, partials and
+	// files named by options such as "--lua-filter", to their SHA-256.
+	// A missing file has an empty hash.
+	Inputs map[string]string `json:"inputs"`
+
+	// Options are the Pandoc options used
+	Options []string `json:"options,omitempty"`
+
+	// KeyMapping is the key mapping used, see NormalizeKeys
+	KeyMapping map[string]string `json:"key_mapping,omitempty"`
+
+	// Engine is "native" or "pandoc" followed by Pandoc's version
+	Engine string `json:"engine"`
+}
+
+// ReadManifest reads a manifest. A missing manifest, or one written by
+// an older version of pdtmpl, is returned empty.
+func ReadManifest(name string) (*Manifest, error) {
+	m := &Manifest{Version: manifestVersion, Outputs: map[string]*ManifestEntry{}, name: name}
+	src, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	saved := &Manifest{}
+	if err := json.Unmarshal(src, saved); err != nil {
+		return nil, fmt.Errorf("%s, %s", name, err)
+	}
+	if saved.Version == manifestVersion && saved.Outputs != nil {
+		m.Outputs = saved.Outputs
+	}
+	return m, nil
+}
+
+// Write saves the manifest to the file it was read from.
+func (m *Manifest) Write() error {
+	m.mu.Lock()
+	src, err := json.MarshalIndent(m, "", "    ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(m.name)
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return err
+	}
+	// Write a copy then rename it so an interrupted build doesn't
+	// leave half a manifest.
+	f, err := os.CreateTemp(dir, ManifestName+".*")
+	if err != nil {
+		return err
+	}
+	tmpFile := f.Name()
+	if _, err := f.Write(append(src, '\n')); err != nil {
+		f.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := os.Chmod(tmpFile, 0664); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, m.name)
+}
+
+// rel makes a file name relative to the manifest's directory.
+func (m *Manifest) rel(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return filepath.ToSlash(name)
+	}
+	dir, err := filepath.Abs(filepath.Dir(m.name))
+	if err != nil {
+		return filepath.ToSlash(name)
+	}
+	if rel, err := filepath.Rel(dir, abs); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(abs)
+}
+
+// path turns a name relative to the manifest's directory back into a
+// file name.
+func (m *Manifest) path(rel string) string {
+	name := filepath.FromSlash(rel)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(m.name), name)
+}
+
+// fresh reports if target exists and was rendered from the same
+// inputs as entry.
+func (m *Manifest) fresh(target string, entry *ManifestEntry) bool {
+	m.mu.Lock()
+	saved, ok := m.Outputs[m.rel(target)]
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if _, err := os.Stat(target); err != nil {
+		return false
+	}
+	a, _ := json.Marshal(saved)
+	b, _ := json.Marshal(entry)
+	return bytes.Equal(a, b)
+}
+
+// set records the entry for target, a nil entry removes it so the
+// target is rebuilt next time.
+func (m *Manifest) set(target string, entry *ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry == nil {
+		delete(m.Outputs, m.rel(target))
+		return
+	}
+	m.Outputs[m.rel(target)] = entry
+}
+
+// prune removes the outputs, and their entries, for which stale
+// returns true. It returns the files removed.
+func (m *Manifest) prune(stale func(target string, entry *ManifestEntry) bool) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	targets := make([]string, 0, len(m.Outputs))
+	for target := range m.Outputs {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	pruned := []string{}
+	for _, target := range targets {
+		if !stale(target, m.Outputs[target]) {
+			continue
+		}
+		name := m.path(target)
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+		delete(m.Outputs, target)
+		pruned = append(pruned, name)
+	}
+	return pruned, nil
+}
+
+// OptionFiles lists the files read by Pandoc options such as
+// "--lua-filter" and "--include-in-header", the "--opt FILE",
+// "--opt=FILE" and short "-L FILE" or "-LFILE" forms are recognized.
+// Files written, e.g. by "--output", and directories searched aren't
+// listed.
+//
+//```
+//  files := pdtmpl.OptionFiles([]string{"--lua-filter=links.lua", "-s"})
+//  // files is []string{"links.lua"}
+//```
+//
+func OptionFiles(options []string) []string {
+	files := []string{}
+	for _, opt := range parseOptions(options) {
+		if opt.name == "" || !opt.hasValue {
+			continue
+		}
+		if kind, paths := optionPaths(opt.name, opt.value); kind == inputPath {
+			files = append(files, paths...)
+		}
+	}
+	return files
+}
+
+// dependencies works out manifest entries, hashing each file once.
+type dependencies struct {
+	r      *Renderer
+	m      *Manifest
+	mu     sync.Mutex
+	hashes map[string]string
+	engine string
+}
+
+// newDependencies prepares to work out entries for m.
+func (r *Renderer) newDependencies(m *Manifest) *dependencies {
+	return &dependencies{r: r, m: m, hashes: map[string]string{}}
+}
+
+// hashFile returns the SHA-256 of a file, empty when it can't be read.
+func (d *dependencies) hashFile(name string) string {
+	if d.r.Dir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(d.r.Dir, name)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h, ok := d.hashes[name]; ok {
+		return h
+	}
+	h := ""
+	if src, err := ioutil.ReadFile(name); err == nil {
+		sum := sha256.Sum256(src)
+		h = hex.EncodeToString(sum[:])
+	}
+	d.hashes[name] = h
+	return h
+}
+
+// pandocEngine names the Pandoc used, it is only asked once.
+func (d *dependencies) pandocEngine() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.engine == "" {
+		d.engine = "pandoc"
+		if info, err := d.r.PandocInfo(); err == nil {
+			d.engine += " " + info.Version
+		}
+	}
+	return d.engine
+}
+
+// entry describes a render of source (a file, or "" for a record) with
+// template and options. Body is true when Pandoc converts a document
+// body.
+func (d *dependencies) entry(source string, body bool, template string, options []string) *ManifestEntry {
+	options = append(append([]string{}, d.r.Options...), options...)
+	entry := &ManifestEntry{
+		Inputs:     map[string]string{},
+		Options:    options,
+		KeyMapping: d.r.KeyMapping,
+	}
+	files := []string{}
+	if source != "" {
+		entry.Source = d.m.rel(source)
+		files = append(files, source)
+	}
+	if template != "" {
+		files = append(files, template)
+		name := template
+		if d.r.Dir != "" && !filepath.IsAbs(name) {
+			// Absolute so the partials aren't joined to Dir twice.
+			name, _ = filepath.Abs(filepath.Join(d.r.Dir, name))
+		}
+		// A template which doesn't parse is still hashed, the render
+		// reports the problem.
+		if t, err := ReadTemplate(name); err == nil {
+			files = append(files, t.Partials()...)
+		}
+	}
+	files = append(files, OptionFiles(options)...)
+	for _, name := range files {
+		entry.Inputs[d.m.rel(name)] = d.hashFile(name)
+	}
+	if !body && d.r.useNative(template, options) {
+		entry.Engine = "native"
+	} else {
+		entry.Engine = d.pandocEngine()
+	}
+	return entry
+}
+
+// record describes a render of a RenderEach record.
+func (d *dependencies) record(outPattern string, data map[string]interface{}, template string, options []string) *ManifestEntry {
+	entry := d.entry("", false, template, options)
+	entry.Batch = outPattern
+	if src, err := CanonicalJSON(data); err == nil {
+		sum := sha256.Sum256(src)
+		entry.Data = hex.EncodeToString(sum[:])
+	}
+	return entry
+}
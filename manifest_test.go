@@ -0,0 +1,53 @@
+// manifest_test.go checks the inputs recorded in a manifest.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOptionFiles(t *testing.T) {
+	for _, tc := range []struct {
+		options  []string
+		expected []string
+	}{
+		{[]string{"--lua-filter=links.lua", "-s"}, []string{"links.lua"}},
+		{[]string{"-L", "a.lua", "-Fb", "-sHhead.html"}, []string{"a.lua", "b", "head.html"}},
+		{[]string{"--epub-cover-image=x.png", "--epub-metadata", "m.xml", "--epub-embed-font=f.ttf"}, []string{"x.png", "m.xml", "f.ttf"}},
+		{[]string{"--highlight-style=my.theme", "--highlight-style=tango"}, []string{"my.theme"}},
+		{[]string{"-t", "writer.lua+smart", "--to=html"}, []string{"writer.lua"}},
+		// Outputs, searched directories and plain values aren't inputs.
+		{[]string{"-o", "out.html", "--resource-path=a:b", "--css=site.css", "in.md"}, []string{}},
+	} {
+		if files := OptionFiles(tc.options); !reflect.DeepEqual(files, tc.expected) {
+			t.Errorf("%q, expected %q, got %q", tc.options, tc.expected, files)
+		}
+	}
+}
+
+func TestManifestOptionInputs(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "x.png"), []byte("cover"), 0664); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadManifest(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Renderer{Engine: EngineNative}
+	cover := filepath.Join(dir, "x.png")
+	entry := r.newDependencies(m).entry("", false, "", []string{"--epub-cover-image=" + cover})
+	if h, ok := entry.Inputs["x.png"]; !ok || h == "" {
+		t.Errorf("expected x.png in the manifest inputs, got %v", entry.Inputs)
+	}
+}
@@ -0,0 +1,90 @@
+// markdown.go renders Markdown documents whose YAML front matter
+// supplies the template variables.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"context"
+)
+
+// SplitFrontMatter separates the YAML front matter block of a Markdown
+// document from its body. The block starts with a "---" line at the top
+// of the document and ends with a "---" or "..." line. When there is no
+// front matter block front is nil and body is the whole document.
+//
+//```
+//  front, body := pdtmpl.SplitFrontMatter(src)
+//  data, err := pdtmpl.DecodeDocument(front, pdtmpl.FormatYAML)
+//```
+//
+func SplitFrontMatter(src []byte) ([]byte, []byte) {
+	src = bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))
+	line, rest := nextLine(src)
+	if string(bytes.TrimRight(line, " \t\r\n")) != "---" {
+		return nil, src
+	}
+	front := rest
+	for offset := 0; len(rest) > 0; {
+		line, rest = nextLine(rest)
+		switch string(bytes.TrimRight(line, " \t\r\n")) {
+		case "---", "...":
+			return front[:offset], rest
+		}
+		offset += len(line)
+	}
+	// An unclosed block is not front matter, e.g. a horizontal rule.
+	return nil, src
+}
+
+// nextLine returns the first line of src, including the newline, and
+// what follows.
+func nextLine(src []byte) ([]byte, []byte) {
+	if i := bytes.IndexByte(src, '\n'); i >= 0 {
+		return src[:i+1], src[i+1:]
+	}
+	return src, nil
+}
+
+// decodeFrontMatter decodes a front matter block, an empty block is an
+// empty object.
+func decodeFrontMatter(front []byte) (map[string]interface{}, error) {
+	if len(bytes.TrimSpace(front)) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	data, err := DecodeDocument(front, FormatYAML)
+	if de, ok := err.(*DecodeError); ok && de.Line > 0 {
+		// Count from the top of the file, past the "---".
+		de.Line++
+	}
+	return data, err
+}
+
+// RenderMarkdown renders a Markdown document with Pandoc. The front
+// matter is decoded and passed as metadata, as RenderData does, and the
+// body is converted by Pandoc and available in the template as
+// "$body$".
+//
+//```
+//  src, _ := ioutil.ReadFile("index.md")
+//  page, err := r.RenderMarkdown(ctx, src, "page.tmpl", nil)
+//```
+//
+func (r *Renderer) RenderMarkdown(ctx context.Context, src []byte, template string, options []string) ([]byte, error) {
+	front, body := SplitFrontMatter(src)
+	data, err := decodeFrontMatter(front)
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return r.renderBody(ctx, data, body, template, options)
+}
@@ -0,0 +1,339 @@
+// metavalue.go converts metadata strings the way Pandoc does before
+// they reach a template, treating them as Markdown and writing HTML.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	reParagraphBreak = regexp.MustCompile(`\n[ \t]*\n\s*`)
+	reEntity         = regexp.MustCompile(`^&(#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);`)
+	reRawTag         = regexp.MustCompile(`^</?[A-Za-z][A-Za-z0-9-]*(\s+[^<>]*)?/?>|^<!--.*?-->`)
+	reAutoLink       = regexp.MustCompile(`^<([A-Za-z][A-Za-z0-9+.-]+:[^\s<>]*)>`)
+	reEmailLink      = regexp.MustCompile(`^<([^\s<>@]+@[^\s<>@]+)>`)
+)
+
+// markdownInlines renders a metadata string as Pandoc's HTML writer
+// would. Only the commonly used subset of Markdown is understood,
+// emphasis, strong emphasis, code, links, autolinks, raw HTML, entities
+// and smart punctuation. Strings holding more than one paragraph are
+// wrapped in <p> elements.
+func markdownInlines(s string) []litem {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	paras := reParagraphBreak.Split(s, -1)
+	if len(paras) == 1 {
+		w := new(inlineWriter)
+		w.inlines([]rune(s))
+		return w.done()
+	}
+	items := []litem{}
+	for i, para := range paras {
+		if i > 0 {
+			items = append(items, litem{kind: itemNewline})
+		}
+		w := new(inlineWriter)
+		w.text("<p>")
+		w.inlines([]rune(para))
+		w.text("</p>")
+		items = append(items, w.done()...)
+	}
+	return items
+}
+
+// inlineWriter collects words and breakable spaces.
+type inlineWriter struct {
+	items []litem
+	word  strings.Builder
+}
+
+func (w *inlineWriter) text(s string) {
+	w.word.WriteString(s)
+}
+
+func (w *inlineWriter) flush() {
+	if w.word.Len() > 0 {
+		w.items = append(w.items, textItems(w.word.String())...)
+		w.word.Reset()
+	}
+}
+
+func (w *inlineWriter) space() {
+	w.flush()
+	if n := len(w.items); n > 0 && w.items[n-1].kind != itemSpace {
+		w.items = append(w.items, litem{kind: itemSpace})
+	}
+}
+
+func (w *inlineWriter) done() []litem {
+	w.flush()
+	if n := len(w.items); n > 0 && w.items[n-1].kind == itemSpace {
+		w.items = w.items[:n-1]
+	}
+	return w.items
+}
+
+// escapeText escapes the characters Pandoc escapes in HTML text.
+func escapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// escapeAttr escapes an HTML attribute value.
+func escapeAttr(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+// isMarkdownPunct reports if a backslash may escape r.
+func isMarkdownPunct(r rune) bool {
+	return r < 0x80 && unicode.IsPunct(r) || r < 0x80 && unicode.IsSymbol(r)
+}
+
+// inlines renders a run of Markdown inline text.
+func (w *inlineWriter) inlines(rs []rune) {
+	quotes := smartQuotes(rs)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		rest := string(rs[i:])
+		switch {
+		case unicode.IsSpace(c):
+			for i < len(rs) && unicode.IsSpace(rs[i]) {
+				i++
+			}
+			w.space()
+			continue
+		case c == '\\' && i+1 < len(rs) && isMarkdownPunct(rs[i+1]):
+			w.text(escapeText(string(rs[i+1])))
+			i += 2
+			continue
+		case c == '`':
+			if n, code, ok := codeSpan(rs[i:]); ok {
+				w.text("<code>" + escapeText(code) + "</code>")
+				i += n
+				continue
+			}
+		case c == '*' || c == '_':
+			if n, ok := w.emphasis(rs, i); ok {
+				i += n
+				continue
+			}
+		case c == '[':
+			if n, ok := w.link(rs, i); ok {
+				i += n
+				continue
+			}
+		case c == '<':
+			if m := reAutoLink.FindStringSubmatch(rest); m != nil {
+				w.text(`<a href="` + escapeAttr(m[1]) + `" class="uri">` + escapeText(m[1]) + `</a>`)
+				i += len([]rune(m[0]))
+				continue
+			}
+			if m := reEmailLink.FindStringSubmatch(rest); m != nil {
+				w.text(`<a href="mailto:` + escapeAttr(m[1]) + `" class="email">` + escapeText(m[1]) + `</a>`)
+				i += len([]rune(m[0]))
+				continue
+			}
+			if m := reRawTag.FindString(rest); m != "" {
+				w.text(m)
+				i += len([]rune(m))
+				continue
+			}
+		case c == '&':
+			if m := reEntity.FindString(rest); m != "" {
+				w.text(escapeText(html.UnescapeString(m)))
+				i += len([]rune(m))
+				continue
+			}
+		case c == '.' && strings.HasPrefix(rest, "..."):
+			w.text("…")
+			i += 3
+			continue
+		case c == '-' && strings.HasPrefix(rest, "---"):
+			w.text("—")
+			i += 3
+			continue
+		case c == '-' && strings.HasPrefix(rest, "--"):
+			w.text("–")
+			i += 2
+			continue
+		case c == '"' || c == '\'':
+			if q, ok := quotes[i]; ok {
+				w.text(q)
+				i++
+				continue
+			}
+		}
+		w.text(escapeText(string(c)))
+		i++
+	}
+}
+
+// codeSpan matches a backtick code span at the start of rs.
+func codeSpan(rs []rune) (int, string, bool) {
+	n := 0
+	for n < len(rs) && rs[n] == '`' {
+		n++
+	}
+	for i := n; i < len(rs); i++ {
+		if rs[i] != '`' {
+			continue
+		}
+		j := i
+		for j < len(rs) && rs[j] == '`' {
+			j++
+		}
+		if j-i == n {
+			code := strings.TrimSpace(string(rs[n:i]))
+			return j, code, true
+		}
+		i = j
+	}
+	return 0, "", false
+}
+
+// emphasis renders *em*, **strong** and their underscore forms.
+func (w *inlineWriter) emphasis(rs []rune, i int) (int, bool) {
+	c := rs[i]
+	n := 0
+	for i+n < len(rs) && rs[i+n] == c && n < 3 {
+		n++
+	}
+	// The opener must be followed by a non-space and, for "_", not
+	// be inside a word.
+	if i+n >= len(rs) || unicode.IsSpace(rs[i+n]) {
+		return 0, false
+	}
+	if c == '_' && i > 0 && (unicode.IsLetter(rs[i-1]) || unicode.IsDigit(rs[i-1])) {
+		return 0, false
+	}
+	for _, size := range []int{2, 1} {
+		if n < size {
+			continue
+		}
+		delim := strings.Repeat(string(c), size)
+		for j := i + size + 1; j+size <= len(rs); j++ {
+			if string(rs[j:j+size]) != delim || unicode.IsSpace(rs[j-1]) {
+				continue
+			}
+			// Don't close "*" on the first half of "**".
+			if size == 1 && j+1 < len(rs) && rs[j+1] == c {
+				j++
+				continue
+			}
+			if c == '_' && j+size < len(rs) && (unicode.IsLetter(rs[j+size]) || unicode.IsDigit(rs[j+size])) {
+				continue
+			}
+			tag := "em"
+			if size == 2 {
+				tag = "strong"
+			}
+			w.text("<" + tag + ">")
+			w.inlines(rs[i+size : j])
+			w.text("</" + tag + ">")
+			return j + size - i, true
+		}
+	}
+	return 0, false
+}
+
+// link renders [text](url "title").
+func (w *inlineWriter) link(rs []rune, i int) (int, bool) {
+	depth := 0
+	end := -1
+	for j := i; j < len(rs); j++ {
+		if rs[j] == '[' {
+			depth++
+		} else if rs[j] == ']' {
+			depth--
+			if depth == 0 {
+				end = j
+				break
+			}
+		}
+	}
+	if end < 0 || end+1 >= len(rs) || rs[end+1] != '(' {
+		return 0, false
+	}
+	close := -1
+	for j := end + 2; j < len(rs); j++ {
+		if rs[j] == ')' {
+			close = j
+			break
+		}
+	}
+	if close < 0 {
+		return 0, false
+	}
+	target := strings.TrimSpace(string(rs[end+2 : close]))
+	url, title := target, ""
+	if k := strings.IndexAny(target, " \t"); k >= 0 {
+		url = target[:k]
+		title = strings.Trim(strings.TrimSpace(target[k:]), `"`)
+	}
+	url = strings.TrimSuffix(strings.TrimPrefix(url, "<"), ">")
+	w.text(`<a href="` + escapeAttr(url) + `"`)
+	if title != "" {
+		w.text(` title="` + escapeAttr(title) + `"`)
+	}
+	w.text(">")
+	w.inlines(rs[i+1 : end])
+	w.text("</a>")
+	return close + 1 - i, true
+}
+
+// smartQuotes decides which straight quotes become curly quotes,
+// returning the replacement keyed by position.
+func smartQuotes(rs []rune) map[int]string {
+	isWordChar := func(i int) bool {
+		return i >= 0 && i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]))
+	}
+	isSpaceAt := func(i int) bool {
+		return i < 0 || i >= len(rs) || unicode.IsSpace(rs[i])
+	}
+	canOpen := func(i int) bool {
+		return !isSpaceAt(i+1) && (i == 0 || unicode.IsSpace(rs[i-1]) || strings.ContainsRune("([{-–—/", rs[i-1]))
+	}
+	out := map[int]string{}
+	openDouble, openSingle := -1, -1
+	for i, c := range rs {
+		switch c {
+		case '"':
+			if openDouble >= 0 && !isSpaceAt(i-1) {
+				out[openDouble], out[i] = "“", "”"
+				openDouble = -1
+			} else if canOpen(i) {
+				openDouble = i
+			}
+		case '\'':
+			switch {
+			case openSingle >= 0 && !isSpaceAt(i-1) && !isWordChar(i+1):
+				out[openSingle], out[i] = "‘", "’"
+				openSingle = -1
+			case isWordChar(i - 1):
+				// an apostrophe, e.g. "it's" or "90's"
+				out[i] = "’"
+			case canOpen(i):
+				openSingle = i
+			default:
+				out[i] = "’"
+			}
+		}
+	}
+	if openSingle >= 0 {
+		out[openSingle] = "’"
+	}
+	return out
+}
@@ -0,0 +1,492 @@
+// native.go renders parsed Pandoc templates in Go without running
+// Pandoc.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultColumns is the line length Pandoc wraps text at.
+const defaultColumns = 72
+
+// Execute renders the template with data. Data is a decoded JSON or
+// YAML object. String values are treated as Pandoc treats metadata,
+// i.e. as Markdown rendered to HTML.
+func (t *Template) Execute(data map[string]interface{}) ([]byte, error) {
+	ctx := &scope{val: toTval(data)}
+	out := []litem{}
+	if err := evalNodes(t.nodes, ctx, &out); err != nil {
+		if pe, ok := err.(*ParseError); ok && pe.Name == "" {
+			pe.Name = t.Name
+		}
+		return nil, err
+	}
+	return []byte(layout(out, defaultColumns)), nil
+}
+
+//
+// Values
+//
+
+// tvalKind identifies the type of a template value.
+type tvalKind int
+
+const (
+	nullVal tvalKind = iota
+	boolVal
+	simpleVal
+	listVal
+	mapVal
+)
+
+// tval is a value available to a template, it mirrors the values of
+// Pandoc's doctemplates.
+type tval struct {
+	kind tvalKind
+	b    bool
+	doc  []litem
+	list []*tval
+	m    map[string]*tval
+}
+
+// simpleText returns a simple value holding unbreakable text.
+func simpleText(s string) *tval {
+	return &tval{kind: simpleVal, doc: textItems(s)}
+}
+
+// toTval converts decoded JSON or YAML into a template value.
+func toTval(v interface{}) *tval {
+	return convertTval(v, markdownInlines)
+}
+
+// convertTval converts a Go value into a template value, strings are
+// turned into layout items by text.
+func convertTval(v interface{}, text func(string) []litem) *tval {
+	switch x := v.(type) {
+	case nil:
+		return &tval{kind: nullVal}
+	case *tval:
+		return x
+	case bool:
+		return &tval{kind: boolVal, b: x}
+	case string:
+		return &tval{kind: simpleVal, doc: text(x)}
+	case json.Number:
+		return simpleText(x.String())
+	case int:
+		return simpleText(strconv.Itoa(x))
+	case int64:
+		return simpleText(strconv.FormatInt(x, 10))
+	case uint64:
+		return simpleText(strconv.FormatUint(x, 10))
+	case float64:
+		return simpleText(strconv.FormatFloat(x, 'f', -1, 64))
+	case time.Time:
+		if x.Hour() == 0 && x.Minute() == 0 && x.Second() == 0 && x.Nanosecond() == 0 {
+			return simpleText(x.Format("2006-01-02"))
+		}
+		return simpleText(x.Format(time.RFC3339))
+	case []interface{}:
+		list := make([]*tval, len(x))
+		for i, item := range x {
+			list[i] = convertTval(item, text)
+		}
+		return &tval{kind: listVal, list: list}
+	case []string:
+		list := make([]*tval, len(x))
+		for i, item := range x {
+			list[i] = convertTval(item, text)
+		}
+		return &tval{kind: listVal, list: list}
+	case map[string]interface{}:
+		m := make(map[string]*tval, len(x))
+		for k, item := range x {
+			m[k] = convertTval(item, text)
+		}
+		return &tval{kind: mapVal, m: m}
+	case map[interface{}]interface{}:
+		m := make(map[string]*tval, len(x))
+		for k, item := range x {
+			m[fmt.Sprintf("%v", k)] = convertTval(item, text)
+		}
+		return &tval{kind: mapVal, m: m}
+	default:
+		return simpleText(fmt.Sprintf("%v", x))
+	}
+}
+
+// goValue converts a template value into plain Go values, simple
+// values become their text.
+func (v *tval) goValue() interface{} {
+	switch v.kind {
+	case boolVal:
+		return v.b
+	case simpleVal:
+		return v.String()
+	case listVal:
+		list := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			list[i] = item.goValue()
+		}
+		return list
+	case mapVal:
+		m := make(map[string]interface{}, len(v.m))
+		for k, item := range v.m {
+			m[k] = item.goValue()
+		}
+		return m
+	}
+	return nil
+}
+
+// String returns the text of a simple value without wrapping.
+func (v *tval) String() string {
+	out := []litem{}
+	v.render(&out)
+	return layout(noWrap(out), 0)
+}
+
+// truthy reports if a value counts as true in a conditional.
+func (v *tval) truthy() bool {
+	switch v.kind {
+	case boolVal:
+		return v.b
+	case simpleVal:
+		return len(v.doc) > 0
+	case listVal:
+		for _, item := range v.list {
+			if item.truthy() {
+				return true
+			}
+		}
+		return false
+	case mapVal:
+		return true
+	}
+	return false
+}
+
+// render appends the interpolated form of the value to out.
+func (v *tval) render(out *[]litem) {
+	switch v.kind {
+	case boolVal:
+		if v.b {
+			*out = append(*out, textItems("true")...)
+		}
+	case simpleVal:
+		doc := v.doc
+		// A final newline is dropped when interpolating.
+		if n := len(doc); n > 0 && doc[n-1].kind == itemNewline {
+			doc = doc[:n-1]
+		}
+		*out = append(*out, doc...)
+	case listVal:
+		for _, item := range v.list {
+			item.render(out)
+		}
+	case mapVal:
+		*out = append(*out, textItems("true")...)
+	}
+}
+
+//
+// Evaluation
+//
+
+// scope binds a variable path to a value, loops add a scope for "it"
+// and the loop variable.
+type scope struct {
+	parent *scope
+	path   []string
+	val    *tval
+}
+
+// bind returns a child scope with path set to val.
+func (s *scope) bind(path []string, val *tval) *scope {
+	return &scope{parent: s, path: path, val: val}
+}
+
+// lookup finds the value of a variable path.
+func (s *scope) lookup(path []string) *tval {
+	for sc := s; sc != nil; sc = sc.parent {
+		if len(path) < len(sc.path) {
+			continue
+		}
+		matched := true
+		for i, part := range sc.path {
+			if path[i] != part {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return multiLookup(path[len(sc.path):], sc.val)
+		}
+	}
+	return &tval{kind: nullVal}
+}
+
+// multiLookup follows a dotted path through map values.
+func multiLookup(path []string, v *tval) *tval {
+	for _, part := range path {
+		if v.kind != mapVal {
+			return &tval{kind: nullVal}
+		}
+		next, ok := v.m[part]
+		if !ok {
+			return &tval{kind: nullVal}
+		}
+		v = next
+	}
+	return v
+}
+
+// resolve looks up a variable and applies its pipes.
+func (s *scope) resolve(v *tvar) (*tval, error) {
+	return applyPipes(s.lookup(v.path), v.pipes)
+}
+
+// evalNodes renders nodes appending layout items to out.
+func evalNodes(nodes []tnode, ctx *scope, out *[]litem) error {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case textNode:
+			if n.reflow {
+				*out = append(*out, wordItems(n.text)...)
+			} else {
+				*out = append(*out, textItems(n.text)...)
+			}
+		case newlineNode:
+			*out = append(*out, litem{kind: itemNewline})
+		case *interpNode:
+			val, err := ctx.resolve(n.v)
+			if err != nil {
+				return err
+			}
+			val.render(out)
+		case *condNode:
+			val, err := ctx.resolve(n.v)
+			if err != nil {
+				return err
+			}
+			branch := n.els
+			if val.truthy() {
+				branch = n.then
+			}
+			if err := evalNodes(branch, ctx, out); err != nil {
+				return err
+			}
+		case *loopNode:
+			val, err := ctx.resolve(n.v)
+			if err != nil {
+				return err
+			}
+			items := []*tval{}
+			switch val.kind {
+			case nullVal:
+			case listVal:
+				items = val.list
+			default:
+				items = []*tval{val}
+			}
+			for i, item := range items {
+				if i > 0 {
+					if err := evalNodes(n.sep, ctx, out); err != nil {
+						return err
+					}
+				}
+				inner := ctx.bind(n.v.path, item).bind([]string{"it"}, item)
+				if err := evalNodes(n.body, inner, out); err != nil {
+					return err
+				}
+			}
+		case *partialNode:
+			if len(n.pipes) == 0 {
+				if err := evalNodes(n.nodes, ctx, out); err != nil {
+					return err
+				}
+				continue
+			}
+			buf := []litem{}
+			if err := evalNodes(n.nodes, ctx, &buf); err != nil {
+				return err
+			}
+			val, err := applyPipes(&tval{kind: simpleVal, doc: buf}, n.pipes)
+			if err != nil {
+				return err
+			}
+			val.render(out)
+		case *nestNode:
+			*out = append(*out, litem{kind: itemPushNest, n: currentColumn(*out)})
+			if err := evalNodes(n.body, ctx, out); err != nil {
+				return err
+			}
+			*out = append(*out, litem{kind: itemPopNest})
+		}
+	}
+	return nil
+}
+
+//
+// Layout, a small subset of Pandoc's doclayout library
+//
+
+// itemKind identifies a layout item.
+type itemKind int
+
+const (
+	// itemText is text which is never broken
+	itemText itemKind = iota
+	// itemSpace is a space which may become a line break
+	itemSpace
+	// itemNewline is a hard line break
+	itemNewline
+	// itemPushNest indents following lines to column n, or to the
+	// column it is rendered at when n is negative
+	itemPushNest
+	// itemPopNest ends the most recent itemPushNest
+	itemPopNest
+)
+
+// litem is a layout item.
+type litem struct {
+	kind itemKind
+	s    string
+	n    int
+}
+
+// textItems returns s as unbreakable text items.
+func textItems(s string) []litem {
+	if s == "" {
+		return nil
+	}
+	return []litem{{kind: itemText, s: s, n: utf8.RuneCountInString(s)}}
+}
+
+// wordItems returns s with spaces as breakable spaces.
+func wordItems(s string) []litem {
+	items := []litem{}
+	for i, word := range strings.Split(s, " ") {
+		if i > 0 {
+			items = append(items, litem{kind: itemSpace})
+		}
+		items = append(items, textItems(word)...)
+	}
+	return items
+}
+
+// currentColumn works out the column the next item will start at.
+func currentColumn(items []litem) int {
+	col := 0
+	for i := len(items) - 1; i >= 0; i-- {
+		switch items[i].kind {
+		case itemNewline:
+			return col
+		case itemText:
+			col += items[i].n
+		case itemSpace:
+			col++
+		}
+	}
+	return col
+}
+
+// isBreak reports if an item ends an unbreakable run.
+func isBreak(it litem) bool {
+	return it.kind == itemSpace || it.kind == itemNewline
+}
+
+// isLast reports if only nesting markers follow items[i].
+func isLast(items []litem, i int) bool {
+	for _, it := range items[i+1:] {
+		if it.kind != itemPushNest && it.kind != itemPopNest {
+			return false
+		}
+	}
+	return true
+}
+
+// layout renders items wrapping breakable spaces at columns.
+func layout(items []litem, columns int) string {
+	sb := new(strings.Builder)
+	col := 0
+	prefixes := []string{""}
+	prefix := func() string {
+		return prefixes[len(prefixes)-1]
+	}
+	newline := func() {
+		sb.WriteString("\n")
+		col = 0
+	}
+	for i := 0; i < len(items); i++ {
+		it := items[i]
+		switch it.kind {
+		case itemText:
+			if it.n == 0 {
+				continue
+			}
+			if col == 0 && prefix() != "" {
+				sb.WriteString(prefix())
+				col += len(prefix())
+			}
+			sb.WriteString(it.s)
+			col += it.n
+		case itemNewline:
+			// Like doclayout a final newline only ends a
+			// non-empty line.
+			if col == 0 && isLast(items, i) {
+				continue
+			}
+			newline()
+		case itemSpace:
+			// Collapse runs of spaces and drop spaces before a
+			// line break or at the end.
+			j := i + 1
+			for j < len(items) && items[j].kind == itemSpace {
+				j++
+			}
+			i = j - 1
+			if j >= len(items) || items[j].kind == itemNewline {
+				continue
+			}
+			// Measure the text up to the next break
+			off := 0
+			for k := j; k < len(items) && !isBreak(items[k]); k++ {
+				if items[k].kind == itemText {
+					off += items[k].n
+				}
+			}
+			if columns > 0 && col+1+off > columns {
+				newline()
+			} else if col > 0 {
+				sb.WriteString(" ")
+				col++
+			}
+		case itemPushNest:
+			// A negative n nests at the current column.
+			n := it.n
+			if n < 0 {
+				n = col
+			}
+			prefixes = append(prefixes, strings.Repeat(" ", n))
+		case itemPopNest:
+			if len(prefixes) > 1 {
+				prefixes = prefixes[:len(prefixes)-1]
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,121 @@
+// normalize.go rewrites document keys which can't be used as Pandoc
+// template variables, e.g. the "@id" and "@type" keys of JSON-LD.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultKeyMapping rewrites "@" as "at__" so the JSON-LD keys of a
+// codemeta.json file become "at__context", "at__type" and "at__id".
+var DefaultKeyMapping = map[string]string{"@": "at__"}
+
+// reVariableName matches a key usable as a Pandoc template variable.
+var reVariableName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// ParseKeyMapping parses a comma separated list of FROM=TO pairs,
+// e.g. "@=at__,$=dollar__".
+func ParseKeyMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected FROM=TO, found %q", pair)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	if len(mapping) == 0 {
+		return nil, fmt.Errorf("empty key mapping")
+	}
+	return mapping, nil
+}
+
+// keyReplacer builds a replacer trying longer strings first so the
+// result doesn't depend on map order.
+func keyReplacer(mapping map[string]string) *strings.Replacer {
+	keys := make([]string, 0, len(mapping))
+	for k := range mapping {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	pairs := make([]string, 0, len(keys)*2)
+	for _, k := range keys {
+		pairs = append(pairs, k, mapping[k])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// NormalizeKeys returns a copy of a decoded JSON or YAML value with the
+// object keys which are not legal Pandoc variable names rewritten
+// using mapping. When a rewritten key is already in use the existing
+// key is kept.
+//
+//```
+//  data := map[string]interface{}{
+//      "@id": "https://orcid.org/0000-0003-0900-6903",
+//  }
+//  data = pdtmpl.NormalizeKeys(data, pdtmpl.DefaultKeyMapping).(map[string]interface{})
+//  fmt.Println(data["at__id"])
+//```
+//
+func NormalizeKeys(v interface{}, mapping map[string]string) interface{} {
+	return normalizeValue(v, keyReplacer(mapping))
+}
+
+func normalizeValue(v interface{}, replacer *strings.Replacer) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i, item := range x {
+			list[i] = normalizeValue(item, replacer)
+		}
+		return list
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			m[fmt.Sprintf("%v", k)] = item
+		}
+		return normalizeValue(m, replacer)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		renamed := map[string]string{}
+		for k, item := range x {
+			if reVariableName.MatchString(k) {
+				m[k] = normalizeValue(item, replacer)
+			} else {
+				renamed[k] = replacer.Replace(k)
+			}
+		}
+		keys := make([]string, 0, len(renamed))
+		for k := range renamed {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, exists := m[renamed[k]]; !exists {
+				m[renamed[k]] = normalizeValue(x[k], replacer)
+			}
+		}
+		return m
+	}
+	return v
+}
@@ -0,0 +1,368 @@
+// pandocinfo.go detects the installed Pandoc's version and
+// capabilities.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MinimumPandocVersion is the oldest Pandoc release pdtmpl is tested
+// with.
+const MinimumPandocVersion = "2.18"
+
+// DefaultInfoTimeout bounds each Pandoc run made to find its version
+// and capabilities when the Renderer has no Timeout.
+const DefaultInfoTimeout = 10 * time.Second
+
+// Info describes an installed Pandoc.
+type Info struct {
+	// Path to the Pandoc executable
+	Path string `json:"path"`
+
+	// Version as reported by `pandoc --version` (e.g. "3.1.11")
+	Version string `json:"version"`
+
+	// DataDir is Pandoc's user data directory
+	DataDir string `json:"data_dir,omitempty"`
+
+	// InputFormats lists the formats accepted by `--from`
+	InputFormats []string `json:"input_formats,omitempty"`
+
+	// OutputFormats lists the formats accepted by `--to`
+	OutputFormats []string `json:"output_formats,omitempty"`
+
+	// Extensions maps each extension name to true if it is
+	// enabled by default for Markdown
+	Extensions map[string]bool `json:"extensions,omitempty"`
+}
+
+// AtLeast reports if the Pandoc version is the same or newer than
+// version (e.g. "2.18").
+func (info *Info) AtLeast(version string) bool {
+	return compareVersions(info.Version, version) >= 0
+}
+
+// Supports reports if Pandoc supports the named feature, see Features.
+// Unknown feature names are reported as unsupported.
+func (info *Info) Supports(name string) bool {
+	for _, f := range Features {
+		if f.Name == name {
+			return info.AtLeast(f.MinVersion)
+		}
+	}
+	return false
+}
+
+// HasInputFormat reports if Pandoc can read the format.
+func (info *Info) HasInputFormat(format string) bool {
+	return hasString(info.InputFormats, format)
+}
+
+// HasOutputFormat reports if Pandoc can write the format.
+func (info *Info) HasOutputFormat(format string) bool {
+	return hasString(info.OutputFormats, format)
+}
+
+// Feature is a Pandoc capability pdtmpl relies on.
+type Feature struct {
+	// Name identifies the feature
+	Name string
+
+	// MinVersion is the first Pandoc release providing it
+	MinVersion string
+
+	// Description is a short human readable explanation
+	Description string
+}
+
+// Features lists the Pandoc capabilities pdtmpl checks for.
+var Features = []Feature{
+	{"metadata-file", "2.3", "--metadata-file option"},
+	{"dollar-brace", "2.8", "${...} template delimiters"},
+	{"template-pipes", "2.8", "template pipes (e.g. ${title/uppercase})"},
+	{"template-partials", "2.8", "template partials (e.g. ${ styles() })"},
+	{"sandbox", "2.15", "--sandbox option"},
+}
+
+// UnsupportedError is returned when a template or option needs a
+// newer Pandoc than the one installed.
+type UnsupportedError struct {
+	// Feature is the missing capability
+	Feature Feature
+
+	// Version is the installed Pandoc version
+	Version string
+
+	// Source names what needed the feature (e.g. a template file)
+	Source string
+}
+
+// Error implements the error interface.
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s uses %s which requires Pandoc %s or newer, found Pandoc %s",
+		e.Source, e.Feature.Description, e.Feature.MinVersion, e.Version)
+}
+
+var (
+	infoMu    sync.Mutex
+	infoCache = map[string]*Info{}
+	infoCalls = map[string]*infoCall{}
+)
+
+// infoCall is a query of Pandoc in progress, callers asking about the
+// same Pandoc meanwhile wait for its result.
+type infoCall struct {
+	done chan struct{}
+	info *Info
+	err  error
+}
+
+// PandocInfo reports on the Pandoc used by the package level
+// functions. Pandoc is only run until it answers, later calls return
+// the same result.
+func PandocInfo() (*Info, error) {
+	return DefaultRenderer().PandocInfo()
+}
+
+// PandocInfo reports on the Renderer's Pandoc. The result is cached
+// per Pandoc executable and environment, Env may change the data
+// directory reported. Dir doesn't change what Pandoc reports so it
+// isn't part of the cache key, nor is Timeout. A failed query, e.g.
+// one which timed out, isn't cached and the next call asks again.
+func (r *Renderer) PandocInfo() (*Info, error) {
+	pandoc, err := r.pandocPath()
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Join(append([]string{pandoc}, r.Env...), "\x00")
+	infoMu.Lock()
+	if info, ok := infoCache[key]; ok {
+		infoMu.Unlock()
+		return info, nil
+	}
+	if call, ok := infoCalls[key]; ok {
+		infoMu.Unlock()
+		<-call.done
+		return call.info, call.err
+	}
+	call := &infoCall{done: make(chan struct{})}
+	infoCalls[key] = call
+	infoMu.Unlock()
+
+	call.info, call.err = r.queryPandoc(pandoc)
+
+	infoMu.Lock()
+	delete(infoCalls, key)
+	if call.err == nil {
+		infoCache[key] = call.info
+	}
+	infoMu.Unlock()
+	close(call.done)
+	return call.info, call.err
+}
+
+// queryPandoc runs Pandoc's --version and --list-* options. Each run
+// is stopped after the Renderer's Timeout, or DefaultInfoTimeout, so a
+// Pandoc which hangs doesn't block the renders waiting on the result.
+func (r *Renderer) queryPandoc(pandoc string) (*Info, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultInfoTimeout
+	}
+	run := func(arg string) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.Command(pandoc, arg)
+		cmd.Dir, cmd.Env = r.Dir, r.Env
+		stdout := new(bytes.Buffer)
+		cmd.Stdout = stdout
+		setProcessGroup(cmd)
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("%s %s, %s", pandoc, arg, err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- cmd.Wait()
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				return nil, fmt.Errorf("%s %s, %s", pandoc, arg, err)
+			}
+		case <-ctx.Done():
+			killProcessTree(cmd)
+			<-done
+			return nil, fmt.Errorf("%s %s, timed out after %s", pandoc, arg, timeout)
+		}
+		return stdout.Bytes(), nil
+	}
+	src, err := run("--version")
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseVersionText(src)
+	if err != nil {
+		return nil, err
+	}
+	info.Path = pandoc
+	if src, err = run("--list-input-formats"); err == nil {
+		info.InputFormats = splitLines(src)
+	}
+	if src, err = run("--list-output-formats"); err == nil {
+		info.OutputFormats = splitLines(src)
+	}
+	if src, err = run("--list-extensions"); err == nil {
+		info.Extensions = map[string]bool{}
+		for _, ext := range splitLines(src) {
+			if len(ext) > 1 && (ext[0] == '+' || ext[0] == '-') {
+				info.Extensions[ext[1:]] = ext[0] == '+'
+			}
+		}
+	}
+	return info, nil
+}
+
+// parseVersionText reads the output of `pandoc --version`.
+func parseVersionText(src []byte) (*Info, error) {
+	info := new(Info)
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case info.Version == "" && strings.HasPrefix(line, "pandoc"):
+			if fields := strings.Fields(line); len(fields) > 1 {
+				info.Version = fields[1]
+			}
+		case strings.HasPrefix(line, "User data directory:"):
+			info.DataDir = strings.TrimSpace(strings.TrimPrefix(line, "User data directory:"))
+		case strings.HasPrefix(line, "Default user data directory:"):
+			// Pandoc before 2.8 lists "Default user data directory: A or B"
+			dirs := strings.TrimSpace(strings.TrimPrefix(line, "Default user data directory:"))
+			info.DataDir = strings.TrimSpace(strings.SplitN(dirs, " or ", 2)[0])
+		}
+	}
+	if info.Version == "" {
+		return nil, fmt.Errorf("could not find a version number in pandoc --version output")
+	}
+	return info, nil
+}
+
+// splitLines returns the non-empty lines of src.
+func splitLines(src []byte) []string {
+	lines := []string{}
+	for _, line := range strings.Split(string(src), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// hasString reports if s is in list.
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares dotted version numbers returning -1, 0 or
+// 1. Missing parts count as zero so "2.18" equals "2.18.0".
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x, _ = strconv.Atoi(strings.TrimFunc(pa[i], notDigit))
+		}
+		if i < len(pb) {
+			y, _ = strconv.Atoi(strings.TrimFunc(pb[i], notDigit))
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func notDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+var (
+	reDollarBrace = regexp.MustCompile(`\$\{`)
+	rePipe        = regexp.MustCompile(`\$\{?\s*[A-Za-z][A-Za-z0-9_.\-]*(\(\))?/[a-z]`)
+	rePartial     = regexp.MustCompile(`\$\{?\s*([A-Za-z][A-Za-z0-9_.\-]*:)?[A-Za-z][A-Za-z0-9_.\-]*\(\)`)
+)
+
+// templateFeatures returns the features a template's source uses.
+func templateFeatures(src []byte) []string {
+	// Keywords look like partials, blank them out first.
+	src = reKeywordCall.ReplaceAll(src, nil)
+	features := []string{}
+	if reDollarBrace.Match(src) {
+		features = append(features, "dollar-brace")
+	}
+	if rePipe.Match(src) {
+		features = append(features, "template-pipes")
+	}
+	if rePartial.Match(src) {
+		features = append(features, "template-partials")
+	}
+	return features
+}
+
+var reKeywordCall = regexp.MustCompile(`\$\{?\s*(if|elseif|for)\(`)
+
+// checkTemplate returns an *UnsupportedError if the template uses a
+// feature the Renderer's Pandoc lacks. Templates that can't be read
+// (e.g. ones Pandoc finds in its data directory) are left for Pandoc
+// to report on.
+func (r *Renderer) checkTemplate(template string) error {
+	name := template
+	if r.Dir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(r.Dir, name)
+	}
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	used := templateFeatures(src)
+	if len(used) == 0 {
+		return nil
+	}
+	info, err := r.PandocInfo()
+	if err != nil {
+		return err
+	}
+	for _, f := range Features {
+		if hasString(used, f.Name) && !info.AtLeast(f.MinVersion) {
+			return &UnsupportedError{Feature: f, Version: info.Version, Source: template}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// pandocinfo_test.go checks how Pandoc's answers are cached.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestPandocInfoRetries checks a failed query isn't cached but an
+// answer is.
+func TestPandocInfoRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for Pandoc")
+	}
+	dir := t.TempDir()
+	pandoc := filepath.Join(dir, "pandoc")
+	// Fails the first time it is run, then answers.
+	script := `#!/bin/sh
+if [ ! -f "` + dir + `/ran" ]; then
+	touch "` + dir + `/ran"
+	exit 1
+fi
+case "$1" in
+--version) echo "pandoc 3.1.11" ;;
+esac
+`
+	if err := ioutil.WriteFile(pandoc, []byte(script), 0775); err != nil {
+		t.Fatal(err)
+	}
+	r := &Renderer{Pandoc: pandoc}
+	if _, err := r.PandocInfo(); err == nil {
+		t.Fatal("expected the first query to fail")
+	}
+	info, err := r.PandocInfo()
+	if err != nil {
+		t.Fatalf("expected the query to be retried, %s", err)
+	}
+	if info.Version != "3.1.11" {
+		t.Errorf("expected version 3.1.11, got %q", info.Version)
+	}
+	// Now answered Pandoc isn't run again.
+	if err := ioutil.WriteFile(pandoc, []byte("#!/bin/sh\nexit 1\n"), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := r.PandocInfo(); err != nil || info.Version != "3.1.11" {
+		t.Errorf("expected the cached answer, got %v, %v", info, err)
+	}
+	// A different environment asks again.
+	r.Env = []string{"HOME=" + dir}
+	if _, err := r.PandocInfo(); err == nil {
+		t.Errorf("expected a query with a new environment")
+	}
+}
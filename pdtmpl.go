@@ -13,26 +13,16 @@ package pdtmpl
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
-	"os/exec"
 	"strings"
 
 	// 3rd Party libraries
 	"gopkg.in/yaml.v3"
 )
 
-var verbose bool
-
-// SetVerbose when set true will show the Pandoc command
-// envocation before running Pandoc to process the JSON document
-// and template. Mainly useful for debugging.
-func SetVerbose(onoff bool) {
-	verbose = onoff
-}
-
 //
 // Pandoc preprosor for JSON and YAML experiment
 //
@@ -52,23 +42,25 @@ func SetVerbose(onoff bool) {
 //```
 //
 func ReadAllTemplate(r io.Reader, template string, options []string) ([]byte, error) {
-	// Read the JSON input
-	src, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
-	return ApplyTemplate(src, template, options)
+	return ReadAllTemplateContext(context.Background(), r, template, options)
+}
+
+// ReadAllTemplateContext is ReadAllTemplate run under a context,
+// see ApplyTemplateContext.
+func ReadAllTemplateContext(ctx context.Context, r io.Reader, template string, options []string) ([]byte, error) {
+	return DefaultRenderer().RenderReader(ctx, r, template, options)
 }
 
 // ReadFileTemplate reads a JSON or YAML document from a file then uses Apply
 // and options returning a slice of bytes and error value.
 func ReadFileTemplate(name string, template string, options []string) ([]byte, error) {
-	// Read the JSON or YAML file
-	src, err := ioutil.ReadFile(name)
-	if err != nil {
-		return nil, err
-	}
-	return ApplyTemplate(src, template, options)
+	return ReadFileTemplateContext(context.Background(), name, template, options)
+}
+
+// ReadFileTemplateContext is ReadFileTemplate run under a context,
+// see ApplyTemplateContext.
+func ReadFileTemplateContext(ctx context.Context, name string, template string, options []string) ([]byte, error) {
+	return DefaultRenderer().RenderFile(ctx, name, template, options)
 }
 
 // ApplyIOTemplate reads in JSON from an io.Reader, applies the template
@@ -85,7 +77,13 @@ func ReadFileTemplate(name string, template string, options []string) ([]byte, e
 //```
 //
 func ApplyIOTemplate(r io.Reader, w io.Writer, template string, options []string) error {
-	src, err := ReadAllTemplate(r, template, options)
+	return ApplyIOTemplateContext(context.Background(), r, w, template, options)
+}
+
+// ApplyIOTemplateContext is ApplyIOTemplate run under a context,
+// see ApplyTemplateContext.
+func ApplyIOTemplateContext(ctx context.Context, r io.Reader, w io.Writer, template string, options []string) error {
+	src, err := ReadAllTemplateContext(ctx, r, template, options)
 	if err != nil {
 		return err
 	}
@@ -94,8 +92,8 @@ func ApplyIOTemplate(r io.Reader, w io.Writer, template string, options []string
 }
 
 // ApplyTemplate takes a byte array (like you could read from os.Stdin
-// containing JSON or YAML. It creates a temp file and passes that to
-// Pandoc via `--metadata-file` option along with any additional
+// containing JSON or YAML. It passes that to Pandoc via the
+// `--metadata-file` option (see MetadataOptions) along with any additional
 // pandoc options provided. Pandoc then renders the output either
 // using the template name (if non-empty string) and the
 // additional options passed to Pandoc.
@@ -114,6 +112,28 @@ func ApplyIOTemplate(r io.Reader, w io.Writer, template string, options []string
 //  fmt.Printf("%s\n", src)
 //```
 //
+// If the template uses features the installed Pandoc lacks (e.g.
+// `${...}` delimiters need Pandoc 2.8) an *UnsupportedError is
+// returned without running Pandoc, see PandocInfo.
+//
+// The document is decoded first, syntax errors and documents which
+// are not objects are returned as a *DecodeError giving the line and
+// column. Pandoc is passed the document as canonical JSON, see
+// DecodeDocument.
+//
+// Keys such as JSON-LD's "@id" can't be used as template variables,
+// call SetKeyMapping(pdtmpl.DefaultKeyMapping) to have them rewritten
+// (e.g. as "at__id") first, see NormalizeKeys.
+//
+// The options are passed to Pandoc as they are. When they come from
+// someone you don't trust, e.g. a web form, call SetOptionPolicy so
+// options such as "--lua-filter" and "-o" are refused with an
+// *OptionError and Pandoc runs with "--sandbox", see OptionPolicy.
+//
+// ApplyTemplate and the other package level functions use the
+// settings of DefaultRenderer(). Create a Renderer when you need
+// different settings (e.g. per goroutine).
+//
 // NOTE: If the template name is an empty string then the
 // template option of Pandoc will not be automatically generated.
 // This can be helpful when turning JSON into non-HTML formats like
@@ -139,133 +159,94 @@ func ApplyIOTemplate(r io.Reader, w io.Writer, template string, options []string
 //```
 //
 func ApplyTemplate(src []byte, template string, options []string) ([]byte, error) {
-	pandoc, err := exec.LookPath("pandoc")
-	if err != nil {
-		return nil, err
-	}
-	f, err := os.CreateTemp(".", "pandoc.*.json")
-	if err != nil {
-		return nil, err
-	}
-	tmpFile := f.Name()
-	defer func() {
-		f.Close()
-		//Remove tmpFile
-		os.RemoveAll(tmpFile)
-	}()
-	// Write our JSON or YAML to the temp file, check for errors.
-	_, err = fmt.Fprintf(f, "%s", src)
-	if err != nil {
-		return nil, err
-	}
-	// Download on experience from text link for Monday's
-	// virtual inspection. Install app and stop at conference code
-	// entry.
-	vargs := []string{}
-	vargs = append(vargs, "--metadata-file", tmpFile)
-	if template != "" {
-		vargs = append(vargs, "--template", template)
-	}
-	if (options != nil) && (len(options) > 0) {
-		vargs = append(vargs, options...)
-	}
-	if verbose {
-		fmt.Fprintf(os.Stderr, "%s %s\n", pandoc, strings.Join(vargs, " "))
-	}
-	cmd := exec.Command(pandoc, vargs...)
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
-	}
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-	errMsg, _ := ioutil.ReadAll(stderr)
-	src, _ = ioutil.ReadAll(stdout)
-	if err := cmd.Wait(); err != nil {
-		if len(errMsg) > 0 {
-			return nil, fmt.Errorf("%s, %s\n", errMsg, err)
-		}
-		return nil, err
-	}
-	return src, nil
+	return ApplyTemplateContext(context.Background(), src, template, options)
 }
 
+// ApplyTemplateContext works like ApplyTemplate but runs Pandoc under
+// the supplied context. If the context is cancelled or its deadline
+// passes before Pandoc finishes then the Pandoc process (and any
+// children it started) is killed and an *InterruptedError is returned.
 //
-// WebForm experiment
+//```
+//  ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+//  defer cancel()
+//  src, err := pdtmpl.ApplyTemplateContext(ctx, src, "example.tmpl", opt)
+//  if err != nil {
+//     var ie *pdtmpl.InterruptedError
+//     if errors.As(err, &ie) && ie.TimedOut {
+//        // ... Pandoc took too long
+//     }
+//     // ... handle error
+//  }
+//```
 //
+func ApplyTemplateContext(ctx context.Context, src []byte, template string, options []string) ([]byte, error) {
+	return ApplyTemplateWithMetadata(ctx, src, template, options, nil)
+}
 
-// MkWebForm takes a map[string]interface{} and translates the form structure into HTML
-func MkWebForm(out io.Writer, eout io.Writer, m map[string]interface{}) error {
-	var (
-		eType string
-		eId string
-	)
-	fmt.Fprintf(out, "<form")
-	for _, k := range []string{ "id", "name", "action", "method", "encoding" } {
-		if val, ok := m[k]; ok {
-			fmt.Fprintf(out, " %s=%q", k, val)
-		}
+// ApplyTemplateWithMetadata is ApplyTemplateContext with control over
+// how the document is handed to Pandoc. A nil MetadataOptions is the
+// same as TransportAuto which avoids writing to the working directory.
+//
+//```
+//  // Write the temp file next to the build output instead of
+//  // the OS temp directory.
+//  mo := &pdtmpl.MetadataOptions{
+//     Transport: pdtmpl.TransportDir,
+//     Dir: "build",
+//  }
+//  src, err := pdtmpl.ApplyTemplateWithMetadata(ctx, src, "example.tmpl", opt, mo)
+//  if err != nil {
+//     // ... handle error
+//  }
+//```
+//
+func ApplyTemplateWithMetadata(ctx context.Context, src []byte, template string, options []string, mo *MetadataOptions) ([]byte, error) {
+	r := DefaultRenderer()
+	if mo != nil {
+		r.Metadata = *mo
 	}
-	fmt.Fprintf(out, " >\n")
-	if l, ok := m["elements"].([]interface{}); ok {
-		for _, item := range l {
-			elem := item.(map[string]interface{})
-			if eId, ok = elem["id"].(string); ! ok {
-				eId = ""
-			}
-			if eType, ok = elem["type"].(string); !ok {
-				eType = "text"
-			}
-			// Map the button alias back to the text input type.
-			if eType == "button" {
-				eType = "text"
-			}
-			prefix := "input"
-			if eType == "select" || eType == "textarea" {
-				prefix = eType
-			}
-			if label, ok := elem["label"].(string); ok {
-				label = ""
-				fmt.Fprintf(out, "\t<label for=%q>%s</label><%s type=%q", eId, label, prefix, eType)
-			} else {
-				fmt.Fprintf(out, "\t<%s type=%q", prefix, eType)
-			}
-			if eId != "" {
-				fmt.Fprintf(out, " id=%q", eId)
-			}
-			for _, k := range []string{"class", "name", "value", "required", "placeholdertext", "title", "required", "pattern" } {
-				if val, ok := elem[k].(string); ok {
-					fmt.Fprintf(out, " %s=%q", k, val)
-				}
-			}
-			// Handle special case of select element type, handle the submap of options' value and labels
-			// Handle case of select and textarea otherwise end form element
-			switch eType {
-			case "select":
-				fmt.Fprintf(out, " >\n")
-				if l, ok := elem["options"]; ok {
-					option := l.(map[string]interface{})
-					for val, label := range option {
-						fmt.Fprintf(out, "\t\t<option value=%q>%s</option\n", val, label)
-					}
-				}
-				fmt.Fprintf(out, "\t</select>\n")
-			case "textarea":
-				fmt.Fprintf(out, " ></textarea>\n")
-			default:
-				fmt.Fprintf(out, " >\n")
-			}
-		}
+	return r.Render(ctx, src, template, options)
+}
+
+// InterruptedError is returned by the context aware functions when
+// Pandoc is stopped before it finishes.
+type InterruptedError struct {
+	// TimedOut is true when the context's deadline passed, false
+	// when the context was cancelled.
+	TimedOut bool
+
+	// Err holds the context's error value.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *InterruptedError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("pandoc timed out, %s", e.Err)
 	}
-   	fmt.Fprintf(out, "</form>\n")	
-	return nil
+	return fmt.Sprintf("pandoc cancelled, %s", e.Err)
 }
 
+// Unwrap returns the context's error so errors.Is(err,
+// context.DeadlineExceeded) and errors.Is(err, context.Canceled)
+// work as expected.
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// interrupted wraps a context error in an *InterruptedError.
+func interrupted(err error) error {
+	return &InterruptedError{
+		TimedOut: errors.Is(err, context.DeadlineExceeded),
+		Err:      err,
+	}
+}
+
+//
+// WebForm experiment
+//
+
 // ApplyWebForm reads Markdown present as input and converts the YAML blogs
 // with a form object into HTML blocks with a webform in them.
 //
@@ -278,6 +259,30 @@ func MkWebForm(out io.Writer, eout io.Writer, m map[string]interface{}) error {
 //```
 //
 func ApplyWebForm(in io.Reader, out io.Writer, eout io.Writer, options []string) error {
+	return ApplyWebFormWithOptions(in, out, eout, options, nil)
+}
+
+// ApplyWebFormWithOptions converts the form objects as ApplyWebForm
+// does using MkWebFormWithOptions, wo may be nil. With wo.Strict a form
+// with a control missing a label is reported as an error.
+//
+//```shell
+//    wo := &pdtmpl.WebFormOptions{ Strict: true }
+//    if err := pdtmpl.ApplyWebFormWithOptions(os.Stdin, os.Stdout, os.Stderr, opt, wo); err != nil {
+//       // ... handle error
+//    }
+//```
+//
+func ApplyWebFormWithOptions(in io.Reader, out io.Writer, eout io.Writer, options []string, wo *WebFormOptions) error {
+	return scanWebForms(in, out, eout, func(form map[string]interface{}) error {
+		return MkWebFormWithOptions(out, eout, form, wo)
+	})
+}
+
+// scanWebForms copies Markdown from in to out calling mk for each YAML
+// block holding a form object in place of the block. Errors are
+// reported to eout with their line number and counted.
+func scanWebForms(in io.Reader, out io.Writer, eout io.Writer, mk func(form map[string]interface{}) error) error {
 	scanner := bufio.NewScanner(in)
 	inYaml, inCodeBlock := false, false
 	ymlText := []string{}
@@ -307,7 +312,7 @@ func ApplyWebForm(in io.Reader, out io.Writer, eout io.Writer, options []string)
 						// This isn't a form block.
 						fmt.Fprintf(out, "---\n%s\n---\n", txt)
 					} else {
-						if err := MkWebForm(out, eout, form); err != nil {
+						if err := mk(form); err != nil {
 							fmt.Fprintf(eout, "line %d: %s\n", lineNo, err)
 							eCnt++
 						}
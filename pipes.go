@@ -0,0 +1,408 @@
+// pipes.go implements the Pandoc template pipes for the native engine
+// and a registry for adding custom pipes.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// PipeFunc is a custom template pipe. The value is a decoded
+// JSON/YAML style value (nil, bool, string, []interface{} or
+// map[string]interface{}), args are the pipe's arguments as written in
+// the template. The returned value replaces the piped value, returned
+// strings are used as is rather than treated as Markdown.
+//
+//```
+//  // ${title/slugify}
+//  pdtmpl.RegisterPipe("slugify", func(v interface{}, args []string) (interface{}, error) {
+//      s, ok := v.(string)
+//      if !ok {
+//          return v, nil
+//      }
+//      return strings.ReplaceAll(strings.ToLower(s), " ", "-"), nil
+//  })
+//
+//  // ${updated/date "2006-01-02"}
+//  pdtmpl.RegisterPipe("date", func(v interface{}, args []string) (interface{}, error) {
+//      s, ok := v.(string)
+//      if !ok || len(args) != 1 {
+//          return v, nil
+//      }
+//      t, err := time.Parse(time.RFC3339, s)
+//      if err != nil {
+//          return nil, err
+//      }
+//      return t.Format(args[0]), nil
+//  })
+//```
+//
+type PipeFunc func(v interface{}, args []string) (interface{}, error)
+
+// builtinPipe is a pipe implemented on template values.
+type builtinPipe func(v *tval, args []string) (*tval, error)
+
+// builtinPipes are the pipes documented in the Pandoc manual.
+var builtinPipes = map[string]builtinPipe{
+	"pairs":      pipePairs,
+	"uppercase":  mapText(strings.ToUpper),
+	"lowercase":  mapText(strings.ToLower),
+	"length":     pipeLength,
+	"reverse":    pipeReverse,
+	"first":      pipeFirst,
+	"last":       pipeLast,
+	"rest":       pipeRest,
+	"allbutlast": pipeAllButLast,
+	"chomp":      mapDoc(chompItems),
+	"nowrap":     mapDoc(noWrap),
+	"alpha":      mapText(toAlpha),
+	"roman":      mapText(toRoman),
+	"left":       pipeBlock("left"),
+	"right":      pipeBlock("right"),
+	"center":     pipeBlock("center"),
+}
+
+var (
+	pipesMu     sync.RWMutex
+	customPipes = map[string]PipeFunc{}
+	rePipeName  = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+)
+
+// RegisterPipe adds a custom pipe for the native engine. The built in
+// pipes can't be replaced. Registering a name again replaces the
+// earlier custom pipe, a nil fn removes it.
+func RegisterPipe(name string, fn PipeFunc) error {
+	if !rePipeName.MatchString(name) {
+		return fmt.Errorf("invalid pipe name %q", name)
+	}
+	if _, ok := builtinPipes[name]; ok {
+		return fmt.Errorf("%q is a built in pipe", name)
+	}
+	pipesMu.Lock()
+	defer pipesMu.Unlock()
+	if fn == nil {
+		delete(customPipes, name)
+	} else {
+		customPipes[name] = fn
+	}
+	return nil
+}
+
+// PipeNames returns the names of the built in and registered pipes.
+func PipeNames() []string {
+	pipesMu.RLock()
+	defer pipesMu.RUnlock()
+	names := make([]string, 0, len(builtinPipes)+len(customPipes))
+	for name := range builtinPipes {
+		names = append(names, name)
+	}
+	for name := range customPipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasPipe reports if name is a built in or registered pipe.
+func HasPipe(name string) bool {
+	if _, ok := builtinPipes[name]; ok {
+		return true
+	}
+	pipesMu.RLock()
+	defer pipesMu.RUnlock()
+	_, ok := customPipes[name]
+	return ok
+}
+
+// applyPipes runs a value through a list of pipes.
+func applyPipes(v *tval, pipes []pipeCall) (*tval, error) {
+	for _, pc := range pipes {
+		var err error
+		if fn, ok := builtinPipes[pc.name]; ok {
+			v, err = fn(v, pc.args)
+		} else {
+			pipesMu.RLock()
+			custom, ok := customPipes[pc.name]
+			pipesMu.RUnlock()
+			if !ok {
+				return nil, &ParseError{Line: pc.pos.Line, Column: pc.pos.Column, Msg: fmt.Sprintf("unknown pipe %q", pc.name)}
+			}
+			var res interface{}
+			if res, err = custom(v.goValue(), pc.args); err == nil {
+				v = convertTval(res, plainItems)
+			}
+		}
+		if err != nil {
+			return nil, &ParseError{Line: pc.pos.Line, Column: pc.pos.Column, Msg: fmt.Sprintf("pipe %q, %s", pc.name, err)}
+		}
+	}
+	return v, nil
+}
+
+// plainItems turns text into words and breakable spaces keeping line
+// breaks.
+func plainItems(s string) []litem {
+	items := []litem{}
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			items = append(items, litem{kind: itemNewline})
+		}
+		items = append(items, wordItems(line)...)
+	}
+	return items
+}
+
+// mapDoc applies fn to every simple value, descending into lists and
+// maps.
+func mapDoc(fn func([]litem) []litem) builtinPipe {
+	var apply func(v *tval) *tval
+	apply = func(v *tval) *tval {
+		switch v.kind {
+		case simpleVal:
+			return &tval{kind: simpleVal, doc: fn(v.doc)}
+		case listVal:
+			list := make([]*tval, len(v.list))
+			for i, item := range v.list {
+				list[i] = apply(item)
+			}
+			return &tval{kind: listVal, list: list}
+		case mapVal:
+			m := make(map[string]*tval, len(v.m))
+			for k, item := range v.m {
+				m[k] = apply(item)
+			}
+			return &tval{kind: mapVal, m: m}
+		}
+		return v
+	}
+	return func(v *tval, args []string) (*tval, error) {
+		return apply(v), nil
+	}
+}
+
+// mapText applies fn to the text of every simple value.
+func mapText(fn func(string) string) builtinPipe {
+	return mapDoc(func(doc []litem) []litem {
+		out := make([]litem, len(doc))
+		for i, it := range doc {
+			if it.kind == itemText {
+				it.s = fn(it.s)
+				it.n = utf8.RuneCountInString(it.s)
+			}
+			out[i] = it
+		}
+		return out
+	})
+}
+
+// pipePairs turns a map or list into a list of key/value maps.
+func pipePairs(v *tval, args []string) (*tval, error) {
+	pair := func(k string, val *tval) *tval {
+		return &tval{kind: mapVal, m: map[string]*tval{"key": simpleText(k), "value": val}}
+	}
+	switch v.kind {
+	case mapVal:
+		keys := make([]string, 0, len(v.m))
+		for k := range v.m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		list := make([]*tval, len(keys))
+		for i, k := range keys {
+			list[i] = pair(k, v.m[k])
+		}
+		return &tval{kind: listVal, list: list}, nil
+	case listVal:
+		list := make([]*tval, len(v.list))
+		for i, item := range v.list {
+			list[i] = pair(strconv.Itoa(i+1), item)
+		}
+		return &tval{kind: listVal, list: list}, nil
+	}
+	return v, nil
+}
+
+// pipeLength counts list items, map keys or characters.
+func pipeLength(v *tval, args []string) (*tval, error) {
+	n := 0
+	switch v.kind {
+	case listVal:
+		n = len(v.list)
+	case mapVal:
+		n = len(v.m)
+	case simpleVal:
+		n = utf8.RuneCountInString(v.String())
+	}
+	return simpleText(strconv.Itoa(n)), nil
+}
+
+// pipeReverse reverses a list or the characters of a simple value.
+func pipeReverse(v *tval, args []string) (*tval, error) {
+	switch v.kind {
+	case listVal:
+		list := make([]*tval, len(v.list))
+		for i, item := range v.list {
+			list[len(list)-1-i] = item
+		}
+		return &tval{kind: listVal, list: list}, nil
+	case simpleVal:
+		rs := []rune(v.String())
+		for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+			rs[i], rs[j] = rs[j], rs[i]
+		}
+		return simpleText(string(rs)), nil
+	}
+	return v, nil
+}
+
+// pipeFirst returns the first item of a non-empty list.
+func pipeFirst(v *tval, args []string) (*tval, error) {
+	if v.kind == listVal && len(v.list) > 0 {
+		return v.list[0], nil
+	}
+	return v, nil
+}
+
+// pipeLast returns the last item of a non-empty list.
+func pipeLast(v *tval, args []string) (*tval, error) {
+	if v.kind == listVal && len(v.list) > 0 {
+		return v.list[len(v.list)-1], nil
+	}
+	return v, nil
+}
+
+// pipeRest returns all but the first item of a non-empty list.
+func pipeRest(v *tval, args []string) (*tval, error) {
+	if v.kind == listVal && len(v.list) > 0 {
+		return &tval{kind: listVal, list: v.list[1:]}, nil
+	}
+	return v, nil
+}
+
+// pipeAllButLast returns all but the last item of a non-empty list.
+func pipeAllButLast(v *tval, args []string) (*tval, error) {
+	if v.kind == listVal && len(v.list) > 0 {
+		return &tval{kind: listVal, list: v.list[:len(v.list)-1]}, nil
+	}
+	return v, nil
+}
+
+// chompItems removes trailing newlines and spaces.
+func chompItems(doc []litem) []litem {
+	n := len(doc)
+	for n > 0 && (doc[n-1].kind == itemNewline || doc[n-1].kind == itemSpace) {
+		n--
+	}
+	return doc[:n]
+}
+
+// noWrap turns breakable spaces into plain spaces.
+func noWrap(doc []litem) []litem {
+	out := make([]litem, len(doc))
+	for i, it := range doc {
+		if it.kind == itemSpace {
+			it = litem{kind: itemText, s: " ", n: 1}
+		}
+		out[i] = it
+	}
+	return out
+}
+
+// toAlpha maps 1, 2, 3 ... to a, b, c ... (mod 26).
+func toAlpha(s string) string {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 {
+		return s
+	}
+	return string(rune('a' + (n-1)%26))
+}
+
+// toRoman writes an integer as lowercase roman numerals.
+func toRoman(s string) string {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 || n >= 4000 {
+		return s
+	}
+	numerals := []struct {
+		value  int
+		symbol string
+	}{
+		{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"},
+		{100, "c"}, {90, "xc"}, {50, "l"}, {40, "xl"},
+		{10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+	}
+	sb := new(strings.Builder)
+	for _, num := range numerals {
+		for n >= num.value {
+			sb.WriteString(num.symbol)
+			n -= num.value
+		}
+	}
+	return sb.String()
+}
+
+// pipeBlock renders simple values as a block of a given width,
+// e.g. `left 20 "| " " |"`.
+func pipeBlock(align string) builtinPipe {
+	return func(v *tval, args []string) (*tval, error) {
+		if len(args) < 1 || len(args) > 3 {
+			return nil, fmt.Errorf("expected a width and optional left and right borders")
+		}
+		width, err := strconv.Atoi(args[0])
+		if err != nil || width < 1 {
+			return nil, fmt.Errorf("width must be a positive number, got %q", args[0])
+		}
+		lBorder, rBorder := "", ""
+		if len(args) > 1 {
+			lBorder = args[1]
+		}
+		if len(args) > 2 {
+			rBorder = args[2]
+		}
+		return mapDoc(func(doc []litem) []litem {
+			return blockItems(doc, width, align, lBorder, rBorder)
+		})(v, nil)
+	}
+}
+
+// blockItems lays out doc in a block of width columns, each line
+// aligned and bordered, nested at the column it is placed at.
+func blockItems(doc []litem, width int, align string, lBorder string, rBorder string) []litem {
+	lines := strings.Split(layout(doc, width), "\n")
+	items := []litem{{kind: itemPushNest, n: -1}}
+	for i, line := range lines {
+		if i > 0 {
+			items = append(items, litem{kind: itemNewline})
+		}
+		pad := width - utf8.RuneCountInString(line)
+		if pad < 0 {
+			pad = 0
+		}
+		switch align {
+		case "right":
+			line = strings.Repeat(" ", pad) + line
+		case "center":
+			line = strings.Repeat(" ", pad/2) + line + strings.Repeat(" ", pad-pad/2)
+		default:
+			line += strings.Repeat(" ", pad)
+		}
+		if rBorder == "" {
+			line = strings.TrimRight(line, " ")
+		}
+		items = append(items, textItems(lBorder+line+rBorder)...)
+	}
+	return append(items, litem{kind: itemPopNest})
+}
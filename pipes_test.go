@@ -0,0 +1,148 @@
+// pipes_test.go checks the native engine's template pipes.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// pipeData is the data the pipe templates are rendered with.
+var pipeData = map[string]interface{}{
+	"name":  "pdtmpl Tool",
+	"map":   map[string]interface{}{"b": "2", "a": "1"},
+	"list":  []interface{}{"x", "y", "z"},
+	"empty": []interface{}{},
+	"zero":  "0",
+	"n":     "27",
+	"big":   "4000",
+	"year":  1994,
+	"long":  "abcdefghijklmno",
+	"words": "aa bb cc dd ee ff",
+	"trail": "a b  \n\n",
+}
+
+// renderPipes renders a template with pipeData using the native
+// engine.
+func renderPipes(src string) (string, error) {
+	tmpl, err := ParseTemplate("pipes.tmpl", []byte(src))
+	if err != nil {
+		return "", err
+	}
+	out, err := tmpl.Execute(pipeData)
+	return string(out), err
+}
+
+func TestPipes(t *testing.T) {
+	for _, tc := range []struct {
+		src      string
+		expected string
+	}{
+		{"$name/uppercase$\n", "PDTMPL TOOL\n"},
+		{"$name/lowercase$\n", "pdtmpl tool\n"},
+		{"$name/length$ $list/length$ $map/length$ $empty/length$\n", "11 3 2 0\n"},
+		{"$name/reverse$ $for(list/reverse)$$it$$endfor$\n", "looT lpmtdp zyx\n"},
+		// A map's pairs are sorted by key, a list's keyed from 1.
+		{"$for(map/pairs)$$it.key$=$it.value$;$endfor$\n", "a=1;b=2;\n"},
+		{"$for(list/pairs)$$it.key$=$it.value$;$endfor$\n", "1=x;2=y;3=z;\n"},
+		{"$list/first$ $list/last$ $for(list/rest)$$it$$endfor$ $for(list/allbutlast)$$it$$endfor$\n", "x z yz xy\n"},
+		// An empty list is returned as is.
+		{"[$empty/first$][$empty/last$][$for(empty/rest)$x$endfor$][$for(empty/allbutlast)$x$endfor$]\n", "[][][][]\n"},
+		// Numbers out of range are left alone.
+		{"$zero/alpha$ $n/alpha$ $zero/roman$ $n/roman$ $big/roman$ $year/roman$\n", "0 a 0 xxvii 4000 mcmxciv\n"},
+		{"$name/alpha$ $name/roman$\n", "pdtmpl Tool pdtmpl Tool\n"},
+		// A word longer than the block isn't broken or cut.
+		{`$long/left 10 "|" "|"$` + "\n", "|abcdefghijklmno|\n"},
+		{`$words/left 9 "|" "|"$` + "\n", "|aa bb cc |\n|dd ee ff |\n"},
+		{`$words/right 9 "|" "|"$` + "\n", "| aa bb cc|\n| dd ee ff|\n"},
+		{`$words/center 10 "[" "]"$` + "\n", "[ aa bb cc ]\n[ dd ee ff ]\n"},
+		// Without a right border trailing padding is dropped.
+		{"$words/left 9$\n", "aa bb cc\ndd ee ff\n"},
+		// A block is nested at the column it starts in.
+		{"x $words/left 5$ y\n", "x aa bb\n  cc dd\n  ee ff y\n"},
+		{`$words/nowrap/left 8 "|" "|"$` + "\n", "|aa bb cc dd ee ff|\n"},
+		{"[$trail/chomp$]\n", "[a b]\n"},
+		{"$list/uppercase/reverse/first$\n", "Z\n"},
+	} {
+		out, err := renderPipes(tc.src)
+		if err != nil {
+			t.Errorf("%q, %s", tc.src, err)
+			continue
+		}
+		if out != tc.expected {
+			t.Errorf("%q, expected %q, got %q", tc.src, tc.expected, out)
+		}
+	}
+}
+
+func TestPipeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		msg string
+	}{
+		{"$name/nonesuch$\n", `unknown pipe "nonesuch"`},
+		{"$words/left$\n", "expected a width"},
+		{"$words/left 0$\n", "width must be a positive number"},
+		{`$words/center 10 "[" "]" "!"$` + "\n", "expected a width"},
+	} {
+		_, err := renderPipes(tc.src)
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%q, expected an error with %q, got %v", tc.src, tc.msg, err)
+		}
+	}
+}
+
+func TestRegisterPipe(t *testing.T) {
+	if len(builtinPipes) != 16 {
+		t.Errorf("expected 16 built in pipes, got %d", len(builtinPipes))
+	}
+	for name := range builtinPipes {
+		if !HasPipe(name) {
+			t.Errorf("HasPipe(%q) is false", name)
+		}
+		if err := RegisterPipe(name, func(v interface{}, args []string) (interface{}, error) { return v, nil }); err == nil {
+			t.Errorf("expected an error replacing the built in pipe %q", name)
+		}
+	}
+	if err := RegisterPipe("bad name", func(v interface{}, args []string) (interface{}, error) { return v, nil }); err == nil {
+		t.Errorf("expected an error for an invalid pipe name")
+	}
+
+	err := RegisterPipe("tally", func(v interface{}, args []string) (interface{}, error) {
+		if l, ok := v.([]interface{}); ok {
+			return fmt.Sprintf("%d%s", len(l), strings.Join(args, "")), nil
+		}
+		return nil, fmt.Errorf("not a list")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RegisterPipe("tally", nil)
+	if !HasPipe("tally") {
+		t.Errorf("HasPipe(%q) is false after registering it", "tally")
+	}
+	names := PipeNames()
+	if !sort.StringsAreSorted(names) || len(names) != len(builtinPipes)+1 || !matchAnyString("tally", names) {
+		t.Errorf("unexpected pipe names %v", names)
+	}
+	if out, err := renderPipes(`$list/tally "!"$` + "\n"); err != nil || out != "3!\n" {
+		t.Errorf(`expected "3!\n", got %q, %v`, out, err)
+	}
+	if _, err := renderPipes("$name/tally$\n"); err == nil || !strings.Contains(err.Error(), `pipe "tally", not a list`) {
+		t.Errorf("expected the custom pipe's error, got %v", err)
+	}
+
+	RegisterPipe("tally", nil)
+	if HasPipe("tally") {
+		t.Errorf("HasPipe(%q) is true after removing it", "tally")
+	}
+}
@@ -0,0 +1,489 @@
+// policy.go checks the Pandoc options passed by callers who may be
+// forwarding untrusted input.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// shortOptions maps Pandoc's short options to their long names.
+var shortOptions = map[string]string{
+	"-f": "--from",
+	"-r": "--read",
+	"-t": "--to",
+	"-w": "--write",
+	"-o": "--output",
+	"-d": "--defaults",
+	"-s": "--standalone",
+	"-M": "--metadata",
+	"-V": "--variable",
+	"-c": "--css",
+	"-H": "--include-in-header",
+	"-B": "--include-before-body",
+	"-A": "--include-after-body",
+	"-L": "--lua-filter",
+	"-F": "--filter",
+	"-N": "--number-sections",
+	"-C": "--citeproc",
+	"-D": "--print-default-template",
+	"-v": "--version",
+	"-h": "--help",
+}
+
+// optionPath says what the value of a Pandoc option names.
+type optionPath int
+
+const (
+	// noPath is a value which doesn't name a file
+	noPath optionPath = iota
+
+	// inputPath is a file Pandoc reads
+	inputPath
+
+	// outputPath is a file or directory Pandoc writes
+	outputPath
+
+	// searchPath is a list of directories Pandoc looks in
+	searchPath
+)
+
+// pandocOption describes a Pandoc option known to the policy.
+type pandocOption struct {
+	// value is set when the option takes a value, which may be given
+	// as the next argument
+	value bool
+
+	// path says what the value names. When pathExt is set only values
+	// with that extension name a file, e.g. "--highlight-style" takes
+	// a style name or a ".theme" file and "--to" a format or a ".lua"
+	// custom writer.
+	path    optionPath
+	pathExt string
+
+	// deny puts the option in DefaultDeny
+	deny bool
+}
+
+// pandocOptions are the Pandoc options known to the policy by their
+// long name. "--epub-subdirectory" names a directory inside the EPUB
+// and "--css" a URL so neither is a path.
+var pandocOptions = map[string]pandocOption{
+	"--from":                    {value: true, path: inputPath, pathExt: ".lua"},
+	"--read":                    {value: true, path: inputPath, pathExt: ".lua"},
+	"--to":                      {value: true, path: inputPath, pathExt: ".lua"},
+	"--write":                   {value: true, path: inputPath, pathExt: ".lua"},
+	"--output":                  {value: true, path: outputPath, deny: true},
+	"--defaults":                {value: true, path: inputPath, deny: true},
+	"--metadata":                {value: true},
+	"--metadata-file":           {value: true, path: inputPath, deny: true},
+	"--variable":                {value: true},
+	"--css":                     {value: true},
+	"--include-in-header":       {value: true, path: inputPath, deny: true},
+	"--include-before-body":     {value: true, path: inputPath, deny: true},
+	"--include-after-body":      {value: true, path: inputPath, deny: true},
+	"--lua-filter":              {value: true, path: inputPath, deny: true},
+	"--filter":                  {value: true, path: inputPath, deny: true},
+	"--template":                {value: true, path: inputPath, deny: true},
+	"--data-dir":                {value: true, path: searchPath, deny: true},
+	"--resource-path":           {value: true, path: searchPath, deny: true},
+	"--extract-media":           {value: true, path: outputPath, deny: true},
+	"--log":                     {value: true, path: outputPath, deny: true},
+	"--pdf-engine":              {value: true, deny: true},
+	"--pdf-engine-opt":          {value: true, deny: true},
+	"--reference-doc":           {value: true, path: inputPath},
+	"--bibliography":            {value: true, path: inputPath},
+	"--csl":                     {value: true, path: inputPath},
+	"--citation-abbreviations":  {value: true, path: inputPath},
+	"--syntax-definition":       {value: true, path: inputPath},
+	"--highlight-style":         {value: true, path: inputPath, pathExt: ".theme"},
+	"--abbreviations":           {value: true, path: inputPath},
+	"--epub-cover-image":        {value: true, path: inputPath},
+	"--epub-metadata":           {value: true, path: inputPath},
+	"--epub-embed-font":         {value: true, path: inputPath},
+	"--epub-subdirectory":       {value: true},
+	"--print-default-template":  {value: true, path: inputPath, pathExt: ".lua"},
+	"--print-default-data-file": {value: true, path: inputPath, deny: true},
+	"--print-highlight-style":   {value: true, path: inputPath, pathExt: ".theme"},
+	"--toc-depth":               {value: true},
+	"--number-offset":           {value: true},
+	"--wrap":                    {value: true},
+	"--columns":                 {value: true},
+	"--eol":                     {value: true},
+	"--dpi":                     {value: true},
+	"--tab-stop":                {value: true},
+	"--shift-heading-level-by":  {value: true},
+	"--id-prefix":               {value: true},
+	"--title-prefix":            {value: true},
+	"--top-level-division":      {value: true},
+	"--reference-location":      {value: true},
+	"--markdown-headings":       {value: true},
+	"--email-obfuscation":       {value: true},
+	"--track-changes":           {value: true},
+	"--default-image-extension": {value: true},
+	"--indented-code-classes":   {value: true},
+	"--request-header":          {value: true, deny: true},
+	"--slide-level":             {value: true},
+	"--split-level":             {value: true},
+	"--chunk-template":          {value: true},
+	"--ipynb-output":            {value: true},
+	"--standalone":              {},
+	"--toc":                     {},
+	"--table-of-contents":       {},
+	"--number-sections":         {},
+	"--section-divs":            {},
+	"--html-q-tags":             {},
+	"--ascii":                   {},
+	"--strip-comments":          {},
+	"--no-highlight":            {},
+	"--reference-links":         {},
+	"--preserve-tabs":           {},
+	"--list-tables":             {},
+	"--sandbox":                 {},
+	"--citeproc":                {},
+	"--self-contained":          {},
+	"--embed-resources":         {},
+	"--file-scope":              {},
+	"--incremental":             {},
+	"--listings":                {},
+	"--mathjax":                 {},
+	"--mathml":                  {},
+	"--katex":                   {},
+	"--webtex":                  {},
+	"--gladtex":                 {},
+	"--verbose":                 {},
+	"--quiet":                   {},
+	"--fail-if-warnings":        {},
+	"--trace":                   {deny: true},
+	"--version":                 {},
+	"--help":                    {},
+}
+
+// takesValue reports if a Pandoc option, by its long name, takes a
+// value.
+func takesValue(name string) bool {
+	return pandocOptions[name].value
+}
+
+// optionPaths returns what a Pandoc option's value names, the paths
+// are empty when it isn't a path.
+func optionPaths(name string, value string) (optionPath, []string) {
+	opt := pandocOptions[name]
+	switch {
+	case opt.path == noPath:
+		return noPath, nil
+	case opt.path == searchPath:
+		return searchPath, filepath.SplitList(value)
+	case opt.pathExt != "":
+		// A format may be followed by extensions, e.g.
+		// "writer.lua+smart".
+		i := strings.Index(value, opt.pathExt)
+		if i < 0 {
+			return noPath, nil
+		}
+		value = value[:i+len(opt.pathExt)]
+	}
+	return opt.path, []string{value}
+}
+
+// DefaultDeny lists the options an OptionPolicy refuses when its Deny
+// list is empty. They run programs, write files, read files outside
+// the document or change where Pandoc looks for its data.
+var DefaultDeny = func() []string {
+	names := []string{}
+	for name, opt := range pandocOptions {
+		if opt.deny {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}()
+
+// OptionPolicy limits the Pandoc options a Renderer accepts from the
+// callers of Render, RenderData and the other render functions. The
+// Renderer's own Options are trusted and not checked. Options are
+// matched by their long name so "-o" is "--output" and "--output=x"
+// is "--output".
+//
+// ```
+//
+//	r, _ := pdtmpl.NewRenderer()
+//	r.Policy = &pdtmpl.OptionPolicy{
+//	    Allow: []string{"--to", "--toc", "--standalone", "--css"},
+//	    Root:  "/srv/site",
+//	}
+//	// fails, --lua-filter is not allowed
+//	_, err := r.Render(ctx, src, "page.tmpl", []string{"--lua-filter=x.lua"})
+//
+// ```
+type OptionPolicy struct {
+	// Allow, when not empty, lists the only options accepted
+	Allow []string
+
+	// Deny lists options refused even when allowed, DefaultDeny
+	// when empty
+	Deny []string
+
+	// Root, when set, is the directory the template, input files and
+	// the values of path options (e.g. "--css" is not a path but
+	// "--bibliography" is) must be inside. When empty input files and
+	// path options are refused.
+	Root string
+
+	// NoSandbox stops the policy adding Pandoc's "--sandbox" option,
+	// which keeps Pandoc from reading files other than those named
+	// on its command line. "--sandbox" needs Pandoc 2.15 or newer.
+	NoSandbox bool
+}
+
+// OptionError reports an option refused by an OptionPolicy.
+type OptionError struct {
+	// Option is the option as given
+	Option string
+
+	// Msg says why it was refused
+	Msg string
+}
+
+// Error implements the error interface.
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("option %q %s", e.Option, e.Msg)
+}
+
+// longOption returns the long name of an option.
+func longOption(name string) string {
+	if long, ok := shortOptions[name]; ok {
+		return long
+	}
+	return name
+}
+
+// parsedOption is an option split into its name and value.
+type parsedOption struct {
+	arg      string
+	name     string
+	value    string
+	hasValue bool
+}
+
+// parseOptions splits Pandoc options into names and values, an
+// argument which is not an option (an input file) has no name. Short
+// options may be bundled as Pandoc allows, e.g. "-sNo" "out.html".
+func parseOptions(options []string) []parsedOption {
+	parsed := []parsedOption{}
+	files := false
+	for i := 0; i < len(options); i++ {
+		arg := options[i]
+		switch {
+		case files || arg == "-" || !strings.HasPrefix(arg, "-"):
+			parsed = append(parsed, parsedOption{arg: arg, value: arg, hasValue: true})
+			continue
+		case arg == "--":
+			// the rest are input files
+			files = true
+			continue
+		}
+		opts := []parsedOption{}
+		if strings.HasPrefix(arg, "--") {
+			p := parsedOption{arg: arg, name: arg}
+			if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 {
+				p.name, p.value, p.hasValue = parts[0], parts[1], true
+			}
+			opts = append(opts, p)
+		} else {
+			for j := 1; j < len(arg); j++ {
+				p := parsedOption{arg: arg, name: longOption("-" + arg[j:j+1])}
+				if takesValue(p.name) && j+1 < len(arg) {
+					p.value, p.hasValue = arg[j+1:], true
+					opts = append(opts, p)
+					break
+				}
+				opts = append(opts, p)
+			}
+		}
+		last := &opts[len(opts)-1]
+		if !last.hasValue && takesValue(last.name) && i+1 < len(options) {
+			i++
+			last.value, last.hasValue = options[i], true
+			last.arg += " " + options[i]
+		}
+		parsed = append(parsed, opts...)
+	}
+	return parsed
+}
+
+// hasOption reports if list holds the option.
+func hasOption(list []string, name string) bool {
+	for _, item := range list {
+		if longOption(item) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an *OptionError for the first option the policy
+// refuses. Relative paths are taken relative to dir, Pandoc's working
+// directory ("" for the current one).
+func (p *OptionPolicy) Check(options []string, dir string) error {
+	deny := p.Deny
+	if len(deny) == 0 {
+		deny = DefaultDeny
+	}
+	// Pandoc accepts unambiguous abbreviations of long options, e.g.
+	// "--lua-filt", so only the full names are accepted.
+	known := map[string]bool{}
+	for _, list := range [][]string{deny, p.Allow} {
+		for _, name := range list {
+			known[longOption(name)] = true
+		}
+	}
+	for name := range pandocOptions {
+		known[name] = true
+	}
+	for _, opt := range parseOptions(options) {
+		if strings.HasPrefix(opt.name, "--") && !known[opt.name] {
+			for name := range known {
+				if strings.HasPrefix(name, opt.name) {
+					return &OptionError{Option: opt.arg, Msg: fmt.Sprintf("is abbreviated, use %s", name)}
+				}
+			}
+		}
+		if opt.name == "" {
+			if opt.value == "-" {
+				continue
+			}
+			if p.Root == "" {
+				return &OptionError{Option: opt.arg, Msg: "is an input file, input files are not allowed"}
+			}
+			if err := p.checkPath(opt.arg, opt.value, dir); err != nil {
+				return err
+			}
+			continue
+		}
+		if opt.name == "--sandbox" {
+			continue
+		}
+		if hasOption(deny, opt.name) {
+			return &OptionError{Option: opt.arg, Msg: "is not allowed"}
+		}
+		if len(p.Allow) > 0 && !hasOption(p.Allow, opt.name) {
+			return &OptionError{Option: opt.arg, Msg: "is not in the allowed options"}
+		}
+		if kind, values := optionPaths(opt.name, opt.value); kind != noPath && opt.hasValue {
+			// Pandoc reads files named on the command line even with
+			// --sandbox.
+			if p.Root == "" {
+				return &OptionError{Option: opt.arg, Msg: "names a file, path options need a sandbox root"}
+			}
+			for _, value := range values {
+				if err := p.checkPath(opt.arg, value, dir); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckTemplate returns an *OptionError when the policy has a Root and
+// the template is outside it.
+func (p *OptionPolicy) CheckTemplate(template string, dir string) error {
+	if template == "" || p.Root == "" {
+		return nil
+	}
+	return p.checkPath("--template="+template, template, dir)
+}
+
+// checkPath makes sure a path is inside Root, following symbolic
+// links.
+func (p *OptionPolicy) checkPath(arg string, name string, dir string) error {
+	if name == "-" {
+		return nil
+	}
+	root, err := resolvePath(p.Root)
+	if err != nil {
+		return fmt.Errorf("sandbox root %q, %s", p.Root, err)
+	}
+	if !filepath.IsAbs(name) && dir != "" {
+		name = filepath.Join(dir, name)
+	}
+	fName, err := resolvePath(name)
+	if err != nil {
+		return &OptionError{Option: arg, Msg: err.Error()}
+	}
+	if fName != root && !strings.HasPrefix(fName, root+string(filepath.Separator)) {
+		return &OptionError{Option: arg, Msg: fmt.Sprintf("is outside of %s", p.Root)}
+	}
+	return nil
+}
+
+// resolvePath makes a path absolute following symbolic links. A file
+// which doesn't exist yet is resolved through its nearest existing
+// parent.
+func resolvePath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = parent
+	}
+}
+
+// checkPolicy checks the options and template of a render against the
+// Renderer's Policy.
+func (r *Renderer) checkPolicy(template string, options []string) error {
+	if r.Policy == nil {
+		return nil
+	}
+	if err := r.Policy.Check(options, r.Dir); err != nil {
+		return err
+	}
+	return r.Policy.CheckTemplate(template, r.Dir)
+}
+
+// sandbox adds "--sandbox" to the options of a Pandoc run when the
+// Renderer has a Policy which doesn't turn it off.
+func (r *Renderer) sandbox(options []string) ([]string, error) {
+	if r.Policy == nil || r.Policy.NoSandbox || hasOption(options, "--sandbox") {
+		return options, nil
+	}
+	info, err := r.PandocInfo()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Supports("sandbox") {
+		for _, f := range Features {
+			if f.Name == "sandbox" {
+				return nil, &UnsupportedError{Feature: f, Version: info.Version, Source: "the option policy"}
+			}
+		}
+	}
+	return append(append([]string{}, options...), "--sandbox"), nil
+}
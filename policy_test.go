@@ -0,0 +1,70 @@
+// policy_test.go checks the option policy.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyPathOptions(t *testing.T) {
+	root := t.TempDir()
+	p := &OptionPolicy{Root: root}
+	inside, outside := filepath.Join(root, "x"), filepath.Join(root, "..", "..", "secret")
+	for _, tc := range []struct {
+		option string
+		ok     bool
+	}{
+		{"--bibliography=" + inside + ".bib", true},
+		{"--bibliography=" + outside + ".bib", false},
+		{"--highlight-style=" + inside + ".theme", true},
+		{"--highlight-style=" + outside + ".theme", false},
+		{"--highlight-style=pygments", true},
+		{"--print-highlight-style=" + outside + ".theme", false},
+		{"--to=" + outside + ".lua+smart", false},
+		{"-t" + outside + ".lua", false},
+		{"--to=html5+smart", true},
+		{"--print-default-template=" + outside + ".lua", false},
+		{"--print-default-template=html", true},
+		{"--epub-cover-image=" + outside + ".png", false},
+		{"--epub-metadata=" + outside + ".xml", false},
+		{"--epub-embed-font=" + outside + ".ttf", false},
+		{"--epub-subdirectory=../../EPUB", true},
+	} {
+		err := p.Check([]string{tc.option}, "")
+		if tc.ok && err != nil {
+			t.Errorf("%s, unexpected error %s", tc.option, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s, expected an error", tc.option)
+		}
+	}
+	// Without a root a path can't be contained.
+	if err := (&OptionPolicy{}).Check([]string{"--highlight-style=x.theme"}, ""); err == nil {
+		t.Errorf("expected an error for a path option without a root")
+	}
+}
+
+func TestDefaultDeny(t *testing.T) {
+	for _, name := range []string{"--output", "--lua-filter", "--filter", "--trace"} {
+		if !hasOption(DefaultDeny, name) {
+			t.Errorf("expected %s in DefaultDeny", name)
+		}
+	}
+	for _, name := range DefaultDeny {
+		if _, ok := pandocOptions[name]; !ok {
+			t.Errorf("%s is denied but not a known option", name)
+		}
+	}
+	p := &OptionPolicy{Root: t.TempDir()}
+	if err := p.Check([]string{"-L", "x.lua"}, ""); err == nil {
+		t.Errorf("expected -L to be denied")
+	}
+}
@@ -0,0 +1,133 @@
+// pool.go runs renders concurrently with a bounded number of workers.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// Job is one render for RenderJobs.
+type Job struct {
+	// Data is the decoded document, see DecodeDocument
+	Data map[string]interface{}
+
+	// Template and Options are as for ApplyTemplate
+	Template string
+	Options  []string
+}
+
+// JobResult is the outcome of a Job.
+type JobResult struct {
+	// Out is the rendered document
+	Out []byte
+
+	// Err is set if the render failed
+	Err error
+
+	// Skipped is set when the job was not run, or was stopped,
+	// because an earlier job failed and the Renderer has FailFast set
+	Skipped bool
+}
+
+// workers returns the number of renders to run at once.
+func (r *Renderer) workers() int {
+	if r.Jobs > 0 {
+		return r.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+// RenderJobs renders the jobs running up to r.Jobs of them at once
+// (runtime.NumCPU() when r.Jobs is zero). Each run of Pandoc gets its
+// own metadata file or pipe so jobs don't collide. The results are in
+// the same order as the jobs. When r.FailFast is set the first failure
+// stops the jobs still running, skips the rest and is returned as the
+// error.
+//
+//```
+//  r := &pdtmpl.Renderer{Jobs: 8, FailFast: true}
+//  jobs := []pdtmpl.Job{}
+//  for _, post := range posts {
+//      jobs = append(jobs, pdtmpl.Job{Data: post, Template: "post.tmpl"})
+//  }
+//  results, err := r.RenderJobs(ctx, jobs)
+//  if err != nil {
+//      // ... the first failure
+//  }
+//  for i, res := range results {
+//      // ... res.Out is the page for posts[i]
+//  }
+//```
+//
+func (r *Renderer) RenderJobs(ctx context.Context, jobs []Job) ([]JobResult, error) {
+	results := make([]JobResult, len(jobs))
+	first := r.runJobs(ctx, len(jobs), func(ctx context.Context, i int) error {
+		out, err := r.RenderData(ctx, jobs[i].Data, jobs[i].Template, jobs[i].Options)
+		results[i].Out, results[i].Err = out, err
+		return err
+	}, func(i int) {
+		results[i].Skipped = true
+		results[i].Err = nil
+	})
+	return results, first
+}
+
+// runJobs calls fn for 0 ... n-1 using at most r.workers() goroutines.
+// With r.FailFast the first error cancels the context passed to the
+// calls still running and skip is called for those and the calls never
+// made. It returns the first error when r.FailFast is set.
+func (r *Renderer) runJobs(ctx context.Context, n int, fn func(ctx context.Context, i int) error, skip func(i int)) error {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		first error
+		wg    sync.WaitGroup
+	)
+	next := make(chan int)
+	for w := 0; w < r.workers() && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				err := fn(jobCtx, i)
+				if err == nil || !r.FailFast {
+					continue
+				}
+				mu.Lock()
+				var ie *InterruptedError
+				switch {
+				case first == nil:
+					first = err
+					cancel()
+				case errors.As(err, &ie) && ctx.Err() == nil:
+					// stopped by the earlier failure
+					skip(i)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if jobCtx.Err() != nil && r.FailFast && ctx.Err() == nil {
+			mu.Lock()
+			skip(i)
+			mu.Unlock()
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return first
+}
@@ -0,0 +1,287 @@
+// preview.go serves a content tree, rendering pages on request and
+// reloading the browser when a file changes.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PreviewReloadPath is the event stream the live reload script of a
+// Preview listens to.
+const PreviewReloadPath = "/.pdtmpl/reload"
+
+// previewSources are the extensions tried, in order, for the source of
+// a page.
+var previewSources = []string{".md", ".json", ".yaml", ".yml"}
+
+// reloadScript reloads the page when the Preview reports a change.
+var reloadScript = []byte(`<script>
+(function () {
+  var events = new EventSource("` + PreviewReloadPath + `");
+  events.onmessage = function () { window.location.reload(); };
+})();
+</script>
+`)
+
+// Preview is an http.Handler serving a content tree the way Walk would
+// render it. A request for "about.html" renders "about.md",
+// "about.json", "about.yaml" or "about.yml", whichever is found first,
+// with the Template, or the one Walk would choose when it is empty. A
+// request for a directory renders its "index" page. Other files, e.g.
+// "css/site.css", are served as they are. Hidden files, templates and
+// the sources of pages are not served.
+//
+// HTML pages have a small script added which reloads the page when
+// Watch sees a change to the tree or to a template, partial or file
+// named by an option which a page used.
+//
+//```
+//  preview := pdtmpl.NewPreview(r, "content")
+//  go preview.Watch(ctx, time.Second)
+//  http.ListenAndServe(":8000", preview)
+//```
+//
+type Preview struct {
+	// Options are passed to Pandoc for each page
+	Options []string
+
+	// Ext is the extension pages are requested with, ".html" when
+	// empty
+	Ext string
+
+	// Template renders every page, when empty each page's template is
+	// chosen as Walk would
+	Template string
+
+	r   *Renderer
+	src string
+
+	mu      sync.Mutex
+	deps    map[string]bool
+	clients map[chan struct{}]bool
+}
+
+// NewPreview returns a Preview of the tree under src rendered by r.
+func NewPreview(r *Renderer, src string) *Preview {
+	return &Preview{
+		r:       r,
+		src:     src,
+		deps:    map[string]bool{},
+		clients: map[chan struct{}]bool{},
+	}
+}
+
+// ext returns the extension of the pages.
+func (p *Preview) ext() string {
+	if p.Ext == "" {
+		return ".html"
+	}
+	return p.Ext
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Preview) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == PreviewReloadPath {
+		p.serveReload(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + req.URL.Path)
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			http.NotFound(w, req)
+			return
+		}
+	}
+	if strings.HasSuffix(req.URL.Path, "/") {
+		name = path.Join(name, "index"+p.ext())
+	}
+	fName := filepath.Join(p.src, filepath.FromSlash(name))
+	if strings.HasSuffix(name, p.ext()) {
+		base := strings.TrimSuffix(fName, p.ext())
+		for _, ext := range previewSources {
+			if info, err := os.Stat(base + ext); err == nil && !info.IsDir() {
+				p.servePage(w, req, base+ext)
+				return
+			}
+		}
+	}
+	// Static HTML gets the reload script too.
+	if strings.HasSuffix(name, ".html") {
+		if src, err := ioutil.ReadFile(fName); err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(injectReload(src)))
+			return
+		}
+	}
+	// Templates and page sources aren't published.
+	if fExt := path.Ext(name); fExt == ".tmpl" || matchAnyString(fExt, previewSources) {
+		http.NotFound(w, req)
+		return
+	}
+	http.ServeFile(w, req, fName)
+}
+
+// servePage renders a source file, a failure is shown as a page which
+// reloads once the problem is fixed.
+func (p *Preview) servePage(w http.ResponseWriter, req *http.Request, source string) {
+	template, err := p.Template, error(nil)
+	if template == "" {
+		template, err = chooseTemplate(p.src, source)
+	}
+	p.track(source, template)
+	var out []byte
+	if err == nil {
+		out, err = p.r.renderSource(req.Context(), source, template, p.Options)
+	}
+	if err != nil {
+		if p.r.Logger != nil {
+			p.r.Logger.Printf("%s, %s", source, err)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<pre>%s</pre>\n%s</body>\n</html>\n",
+			html.EscapeString(source), html.EscapeString(source), html.EscapeString(err.Error()), reloadScript)
+		return
+	}
+	contentType := mime.TypeByExtension(p.ext())
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	if strings.HasPrefix(contentType, "text/html") {
+		out = injectReload(out)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, req, path.Base(req.URL.Path), time.Time{}, bytes.NewReader(out))
+}
+
+// track remembers the files a page depends on so Watch sees changes
+// to those outside the tree.
+func (p *Preview) track(source string, template string) {
+	files := []string{source}
+	if template != "" {
+		files = append(files, template)
+		if t, err := ReadTemplate(template); err == nil {
+			files = append(files, t.Partials()...)
+		}
+	}
+	files = append(files, OptionFiles(append(append([]string{}, p.r.Options...), p.Options...))...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range files {
+		p.deps[filepath.Clean(name)] = true
+	}
+}
+
+// watchList lists the tree and the files the pages served depend on.
+func (p *Preview) watchList() []string {
+	files := treeFiles(p.src, "")
+	p.mu.Lock()
+	for name := range p.deps {
+		files = append(files, name)
+	}
+	p.mu.Unlock()
+	return uniqueStrings(files)
+}
+
+// Watch checks the tree, and the templates, partials and filters used
+// by the pages served, every interval (DefaultWatchInterval when zero)
+// and reloads the browsers showing a page when something changes. It
+// returns when ctx is cancelled.
+func (p *Preview) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	pollFiles(ctx, interval, DefaultDebounce, nil, p.watchList, func(files []string) {
+		if p.r.Logger != nil {
+			p.r.Logger.Printf("changed %s", strings.Join(files, ", "))
+		}
+		p.Reload()
+	})
+}
+
+// Reload tells the browsers showing a page to reload it.
+func (p *Preview) Reload() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// a reload is already waiting
+		}
+	}
+}
+
+// serveReload streams an event each time the pages should reload.
+func (p *Preview) serveReload(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan struct{}, 1)
+	p.mu.Lock()
+	p.clients[ch] = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, ch)
+		p.mu.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, ": connected\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprintf(w, "data: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+var reBodyEnd = regexp.MustCompile(`(?i)</body\s*>`)
+
+// injectReload adds the reload script before the closing body tag, or
+// at the end when there isn't one.
+func injectReload(page []byte) []byte {
+	found := reBodyEnd.FindAllIndex(page, -1)
+	if len(found) == 0 {
+		return append(append([]byte{}, page...), reloadScript...)
+	}
+	i := found[len(found)-1][0]
+	out := make([]byte, 0, len(page)+len(reloadScript))
+	out = append(out, page[:i]...)
+	out = append(out, reloadScript...)
+	return append(out, page[i:]...)
+}
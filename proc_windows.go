@@ -0,0 +1,25 @@
+//go:build windows
+
+package pdtmpl
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows, killProcessTree uses
+// taskkill to walk the tree instead.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessTree stops the command and any children it started.
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", fmt.Sprintf("%d", cmd.Process.Pid))
+	if err := kill.Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+// renderer.go holds the Renderer type which carries the settings used
+// to run Pandoc.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Renderer holds the settings for running Pandoc. A Renderer is not
+// modified by rendering so one value can be shared between
+// goroutines, and goroutines needing different settings can each use
+// their own Renderer.
+//
+//```
+//  r, err := pdtmpl.NewRenderer()
+//  if err != nil {
+//     // ... Pandoc is not installed
+//  }
+//  r.Timeout = 30 * time.Second
+//  r.Logger = log.New(os.Stderr, "", 0)
+//  src, err := r.RenderFile(ctx, "example.json", "example.tmpl", nil)
+//  if err != nil {
+//     // ... handle error
+//  }
+//  fmt.Printf("%s\n", src)
+//```
+//
+type Renderer struct {
+	// Pandoc is the path to the Pandoc executable. If empty it is
+	// looked up on the PATH each time Pandoc is run.
+	Pandoc string
+
+	// Options are passed to Pandoc ahead of the options given to
+	// each render call.
+	Options []string
+
+	// Logger, if not nil, is sent the Pandoc command line before
+	// each run.
+	Logger *log.Logger
+
+	// Dir is the working directory Pandoc runs in. An empty string
+	// means the current working directory. Relative template and
+	// file names are resolved by Pandoc relative to Dir.
+	Dir string
+
+	// Env is the environment Pandoc runs with. A nil Env inherits
+	// the environment of the current process.
+	Env []string
+
+	// Timeout, if greater than zero, bounds how long a single
+	// Pandoc run may take.
+	Timeout time.Duration
+
+	// Metadata controls how documents are passed to Pandoc.
+	Metadata MetadataOptions
+
+	// Engine selects Pandoc or the native Go template engine.
+	Engine Engine
+
+	// Format is the format of the documents rendered, FormatJSON,
+	// FormatYAML or FormatTOML. FormatAuto guesses JSON or YAML,
+	// RenderFile uses the file extension first.
+	Format string
+
+	// KeyMapping, if not nil, rewrites the keys of the document
+	// which are not legal Pandoc variable names before rendering,
+	// see NormalizeKeys and DefaultKeyMapping.
+	KeyMapping map[string]string
+
+	// Jobs is the number of renders RenderJobs, RenderEach and the
+	// other batch functions run at once, zero means
+	// runtime.NumCPU().
+	Jobs int
+
+	// FailFast stops a batch at the first failure.
+	FailFast bool
+
+	// Manifest, when set, is the file Walk and RenderEach use to
+	// record the inputs of each output so only outputs whose inputs
+	// changed are rendered again, see ManifestName
+	Manifest string
+
+	// Force renders every output even when the Manifest says it is
+	// up to date
+	Force bool
+
+	// Policy, if not nil, limits the options and templates callers
+	// may pass and runs Pandoc with "--sandbox", see OptionPolicy
+	Policy *OptionPolicy
+}
+
+// NewRenderer returns a Renderer with the path to Pandoc resolved from
+// the PATH.
+func NewRenderer() (*Renderer, error) {
+	pandoc, err := exec.LookPath("pandoc")
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{Pandoc: pandoc}, nil
+}
+
+// pandocPath returns the configured Pandoc executable or looks it up.
+func (r *Renderer) pandocPath() (string, error) {
+	if r.Pandoc != "" {
+		return r.Pandoc, nil
+	}
+	return exec.LookPath("pandoc")
+}
+
+// Render applies the template and options to a JSON, YAML or TOML
+// document, see ApplyTemplate for details.
+func (r *Renderer) Render(ctx context.Context, src []byte, template string, options []string) ([]byte, error) {
+	return r.render(ctx, src, r.Format, template, options)
+}
+
+// render decodes the document in the given format then renders it
+// with RenderData.
+func (r *Renderer) render(ctx context.Context, src []byte, format string, template string, options []string) ([]byte, error) {
+	// Decode the document here so mistakes are reported with a line
+	// and column instead of as a Pandoc YAML error.
+	data, err := DecodeDocument(src, format)
+	if err != nil {
+		return nil, err
+	}
+	return r.RenderData(ctx, data, template, options)
+}
+
+// RenderData applies the template and options to a decoded document,
+// e.g. one returned by DecodeDocument or SplitRecords.
+func (r *Renderer) RenderData(ctx context.Context, data map[string]interface{}, template string, options []string) ([]byte, error) {
+	return r.renderBody(ctx, data, nil, template, options)
+}
+
+// renderBody renders data as RenderData does. A non-nil body is passed
+// to Pandoc as the document to convert, it requires Pandoc.
+func (r *Renderer) renderBody(ctx context.Context, data map[string]interface{}, body []byte, template string, options []string) ([]byte, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, interrupted(err)
+	}
+	if r.KeyMapping != nil {
+		data = NormalizeKeys(data, r.KeyMapping).(map[string]interface{})
+	}
+	if err := r.checkPolicy(template, options); err != nil {
+		return nil, err
+	}
+	if body == nil && r.useNative(template, options) {
+		return r.renderNative(data, template, options)
+	}
+	if r.Engine == EngineNative {
+		return nil, fmt.Errorf("the native engine can't convert a document body, use Pandoc")
+	}
+	src, err := CanonicalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	pandoc, err := r.pandocPath()
+	if err != nil {
+		return nil, err
+	}
+	if options, err = r.sandbox(options); err != nil {
+		return nil, err
+	}
+	if template != "" {
+		if err := r.checkTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+	md, err := openMetadata(&r.Metadata, src, body)
+	if err != nil {
+		return nil, err
+	}
+	defer md.cleanup()
+	vargs := []string{}
+	vargs = append(vargs, md.args...)
+	if template != "" {
+		vargs = append(vargs, "--template", template)
+	}
+	vargs = append(vargs, r.Options...)
+	vargs = append(vargs, options...)
+	if r.Logger != nil {
+		r.Logger.Printf("%s %s", pandoc, strings.Join(vargs, " "))
+	}
+	cmd := exec.Command(pandoc, vargs...)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.Stdin = md.stdin
+	cmd.ExtraFiles = md.extraFiles
+	cmd.Dir = r.Dir
+	cmd.Env = r.Env
+	// Run Pandoc in its own process group so we can stop it along
+	// with anything it has started (e.g. Lua filters, PDF engines).
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	md.started()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killProcessTree(cmd)
+		<-done
+		return nil, interrupted(ctx.Err())
+	}
+	if err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%s, %s\n", stderr.Bytes(), err)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// RenderReader reads a JSON, YAML or TOML document from an io.Reader then
+// renders it with Render.
+func (r *Renderer) RenderReader(ctx context.Context, rd io.Reader, template string, options []string) ([]byte, error) {
+	src, err := ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	return r.Render(ctx, src, template, options)
+}
+
+// RenderFile reads a JSON, YAML or TOML document from a file then
+// renders it with Render. Unless the Renderer's Format is set the
+// format is taken from the file extension (e.g. ".toml").
+func (r *Renderer) RenderFile(ctx context.Context, name string, template string, options []string) ([]byte, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	format := r.Format
+	if format == FormatAuto {
+		format = FormatFromExt(name)
+	}
+	return r.render(ctx, src, format, template, options)
+}
+
+var (
+	defaultMu       sync.Mutex
+	defaultRenderer Renderer
+)
+
+// DefaultRenderer returns a copy of the Renderer used by the package
+// level functions such as ApplyTemplate. Changing the copy does not
+// change the defaults, use SetVerbose for that.
+func DefaultRenderer() *Renderer {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultRenderer.Pandoc == "" {
+		// Remember Pandoc once found, if it isn't installed Render
+		// reports the error.
+		if pandoc, err := exec.LookPath("pandoc"); err == nil {
+			defaultRenderer.Pandoc = pandoc
+		}
+	}
+	r := defaultRenderer
+	return &r
+}
+
+// SetKeyMapping sets the key mapping used by the package level
+// functions, nil turns key normalization off. See NormalizeKeys.
+func SetKeyMapping(mapping map[string]string) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRenderer.KeyMapping = mapping
+}
+
+// SetOptionPolicy sets the option policy used by the package level
+// functions, nil turns it off. See OptionPolicy.
+func SetOptionPolicy(policy *OptionPolicy) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRenderer.Policy = policy
+}
+
+// SetVerbose when set true will show the Pandoc command
+// envocation before running Pandoc to process the JSON document
+// and template. Mainly useful for debugging.
+func SetVerbose(onoff bool) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if onoff {
+		defaultRenderer.Logger = log.New(os.Stderr, "", 0)
+	} else {
+		defaultRenderer.Logger = nil
+	}
+}
@@ -0,0 +1,173 @@
+// table.go reads CSV and TSV files as collections of records.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeTable reads a CSV or TSV file whose first row names the
+// columns. The result is an object with "columns", the column names,
+// and "rows", one object per row. Numbers and booleans ("true" or
+// "false") are converted, everything else is a string.
+//
+//```
+//  name,age,staff
+//  Ada,36,true
+//```
+//
+// becomes
+//
+//```
+//  {
+//      "columns": [ "name", "age", "staff" ],
+//      "rows": [ { "name": "Ada", "age": 36, "staff": true } ]
+//  }
+//```
+//
+func decodeTable(src []byte, format string) (interface{}, error) {
+	rd := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))))
+	if format == FormatTSV {
+		rd.Comma = '\t'
+		rd.LazyQuotes = true
+	}
+	header, err := rd.Read()
+	if err == io.EOF {
+		return map[string]interface{}{"columns": []interface{}{}, "rows": []interface{}{}}, nil
+	}
+	if err != nil {
+		return nil, tableError(format, err)
+	}
+	columns := make([]interface{}, len(header))
+	seen := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, &DecodeError{Format: format, Line: 1, Column: i + 1, Msg: "empty column name"}
+		}
+		if j, ok := seen[name]; ok {
+			return nil, &DecodeError{Format: format, Line: 1, Column: i + 1, Msg: fmt.Sprintf("column %q repeats column %d", name, j+1)}
+		}
+		seen[name] = i
+		header[i], columns[i] = name, name
+	}
+	rows := []interface{}{}
+	for {
+		record, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, tableError(format, err)
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, cell := range record {
+			row[header[i]] = cellValue(cell)
+		}
+		rows = append(rows, row)
+	}
+	return map[string]interface{}{"columns": columns, "rows": rows}, nil
+}
+
+// tableError converts a csv.ParseError to a DecodeError.
+func tableError(format string, err error) error {
+	if pe, ok := err.(*csv.ParseError); ok {
+		return &DecodeError{Format: format, Line: pe.Line, Column: pe.Column, Msg: pe.Err.Error()}
+	}
+	return &DecodeError{Format: format, Msg: err.Error()}
+}
+
+// cellValue infers the type of a cell.
+func cellValue(cell string) interface{} {
+	trimmed := strings.TrimSpace(cell)
+	switch {
+	case reJSONNumber.MatchString(trimmed):
+		return json.Number(trimmed)
+	case strings.EqualFold(trimmed, "true"):
+		return true
+	case strings.EqualFold(trimmed, "false"):
+		return false
+	}
+	return cell
+}
+
+// SplitRecords turns the "rows" of a document, such as a CSV file
+// decoded by DecodeDocument, into one document per row. Each is
+// named by the value of its key column, the names are safe to use as
+// file names and must be unique.
+//
+//```
+//  data, err := pdtmpl.DecodeDocument(src, pdtmpl.FormatCSV)
+//  if err != nil {
+//      // ... handle error
+//  }
+//  names, records, err := pdtmpl.SplitRecords(data, "id")
+//  if err != nil {
+//      // ... handle error
+//  }
+//  for i, record := range records {
+//      src, err := r.RenderData(ctx, record, "staff.tmpl", nil)
+//      if err != nil {
+//          // ... handle error
+//      }
+//      ioutil.WriteFile(names[i]+".html", src, 0664)
+//  }
+//```
+//
+func SplitRecords(data map[string]interface{}, key string) ([]string, []map[string]interface{}, error) {
+	rows, ok := data["rows"].([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("expected a list of rows")
+	}
+	names := make([]string, 0, len(rows))
+	records := make([]map[string]interface{}, 0, len(rows))
+	seen := map[string]int{}
+	for i, item := range rows {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("row %d is not an object", i+1)
+		}
+		value, ok := row[key]
+		if !ok || value == nil {
+			return nil, nil, fmt.Errorf("row %d has no %q", i+1, key)
+		}
+		name := recordName(fmt.Sprintf("%v", value))
+		if name == "" {
+			return nil, nil, fmt.Errorf("row %d, %q of %q can't be used as a name", i+1, key, value)
+		}
+		if j, ok := seen[name]; ok {
+			return nil, nil, fmt.Errorf("rows %d and %d are both named %q", j+1, i+1, name)
+		}
+		seen[name] = i
+		names = append(names, name)
+		records = append(records, row)
+	}
+	return names, records, nil
+}
+
+// recordName makes a value safe to use as a file name.
+func recordName(value string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r < ' ':
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(value))
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,914 @@
+// template.go is a parser for the Pandoc template language used by the
+// native engine.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxPartialDepth limits how deeply partials may include partials,
+// Pandoc uses the same limit.
+const maxPartialDepth = 50
+
+// Template is a parsed Pandoc template. See
+// https://pandoc.org/MANUAL.html#templates for the template language.
+type Template struct {
+	// Name is the file name of the template, partials are found
+	// relative to it.
+	Name string
+
+	nodes []tnode
+}
+
+// ParseError describes a syntax error in a template.
+type ParseError struct {
+	// Name of the template or partial
+	Name string
+
+	// Line and Column of the error, starting at 1
+	Line   int
+	Column int
+
+	// Msg describes the error
+	Msg string
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Name, e.Line, e.Column, e.Msg)
+}
+
+// ReadTemplate reads and parses a Pandoc template file.
+func ReadTemplate(name string) (*Template, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplate(name, src)
+}
+
+// ParseTemplate parses the source of a Pandoc template. Name is used
+// in error messages and to find partials.
+func ParseTemplate(name string, src []byte) (*Template, error) {
+	p := newTemplateParser(name, string(src), 0)
+	nodes, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Name: name, nodes: nodes}, nil
+}
+
+// Partials lists the files of the partials the template includes,
+// directly or through other partials, each once.
+func (t *Template) Partials() []string {
+	files := []string{}
+	seen := map[string]bool{}
+	var walk func(nodes []tnode)
+	walk = func(nodes []tnode) {
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *condNode:
+				walk(n.then)
+				walk(n.els)
+			case *loopNode:
+				walk(n.body)
+				walk(n.sep)
+			case *nestNode:
+				walk(n.body)
+			case *partialNode:
+				if !seen[n.file] {
+					seen[n.file] = true
+					files = append(files, n.file)
+				}
+				walk(n.nodes)
+			}
+		}
+	}
+	walk(t.nodes)
+	return files
+}
+
+//
+// Template syntax tree
+//
+
+// tnode is a node in a parsed template.
+type tnode interface{}
+
+// position of a node in the template source.
+type position struct {
+	Line   int
+	Column int
+}
+
+// textNode is literal text without line endings.
+type textNode struct {
+	text string
+	// reflow is true when spaces may be broken ($~$)
+	reflow bool
+}
+
+// newlineNode is a line ending in the template.
+type newlineNode struct{}
+
+// interpNode interpolates a variable.
+type interpNode struct {
+	pos position
+	v   *tvar
+}
+
+// condNode is an if/elseif/else/endif block.
+type condNode struct {
+	pos  position
+	v    *tvar
+	then []tnode
+	els  []tnode
+}
+
+// loopNode is a for/sep/endfor block.
+type loopNode struct {
+	pos  position
+	v    *tvar
+	body []tnode
+	sep  []tnode
+}
+
+// partialNode includes another template.
+type partialNode struct {
+	pos   position
+	name  string
+	file  string
+	pipes []pipeCall
+	nodes []tnode
+}
+
+// nestNode indents all but the first line of its content to the
+// column it starts at.
+type nestNode struct {
+	body []tnode
+}
+
+// tvar is a variable reference such as `it.name/uppercase`.
+type tvar struct {
+	path  []string
+	pipes []pipeCall
+}
+
+// String returns the variable as written in a template.
+func (v *tvar) String() string {
+	s := strings.Join(v.path, ".")
+	for _, p := range v.pipes {
+		s += "/" + p.String()
+	}
+	return s
+}
+
+// pipeCall is a pipe with its arguments.
+type pipeCall struct {
+	pos  position
+	name string
+	args []string
+}
+
+// String returns the pipe as written in a template.
+func (pc pipeCall) String() string {
+	s := pc.name
+	for _, arg := range pc.args {
+		if _, err := strconv.Atoi(arg); err == nil {
+			s += " " + arg
+		} else {
+			s += " " + strconv.Quote(arg)
+		}
+	}
+	return s
+}
+
+// reservedWords may not be used as variable names.
+var reservedWords = map[string]bool{
+	"if": true, "else": true, "elseif": true, "endif": true,
+	"for": true, "sep": true, "endfor": true,
+}
+
+//
+// Parser
+//
+
+// templateParser is a recursive descent parser following the grammar
+// of Pandoc's doctemplates library.
+type templateParser struct {
+	name   string
+	src    string
+	offset int
+	line   int
+	col    int
+	depth  int
+	reflow bool
+	// nestedCol is the column of an enclosing $^$, zero if none
+	nestedCol int
+}
+
+func newTemplateParser(name string, src string, depth int) *templateParser {
+	return &templateParser{name: name, src: src, line: 1, col: 1, depth: depth}
+}
+
+// state is a saved parser position used to backtrack.
+type parserState struct {
+	offset, line, col int
+}
+
+func (p *templateParser) save() parserState {
+	return parserState{p.offset, p.line, p.col}
+}
+
+func (p *templateParser) restore(s parserState) {
+	p.offset, p.line, p.col = s.offset, s.line, s.col
+}
+
+func (p *templateParser) pos() position {
+	return position{p.line, p.col}
+}
+
+func (p *templateParser) errorf(pos position, format string, args ...interface{}) error {
+	return &ParseError{Name: p.name, Line: pos.Line, Column: pos.Column, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *templateParser) eof() bool {
+	return p.offset >= len(p.src)
+}
+
+func (p *templateParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.offset]
+}
+
+func (p *templateParser) hasPrefix(s string) bool {
+	return strings.HasPrefix(p.src[p.offset:], s)
+}
+
+// advance moves past n bytes keeping the line and column current.
+func (p *templateParser) advance(n int) {
+	for i := 0; i < n && !p.eof(); i++ {
+		c := p.src[p.offset]
+		p.offset++
+		if c == '\n' {
+			p.line++
+			p.col = 1
+		} else if c < 0x80 || c >= 0xC0 {
+			// count runes, not continuation bytes
+			p.col++
+		}
+	}
+}
+
+func (p *templateParser) skipSpaces() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.advance(1)
+	}
+}
+
+// lineEnding returns the length of the line ending at the current
+// position or zero.
+func (p *templateParser) lineEnding() int {
+	if p.hasPrefix("\r\n") {
+		return 2
+	}
+	if p.hasPrefix("\n") || p.hasPrefix("\r") {
+		return 1
+	}
+	return 0
+}
+
+// endline consumes a line ending. Inside $^$ the indentation of the
+// next line up to the nesting column is consumed too, a line indented
+// less ends the nested block so endline fails.
+func (p *templateParser) endline() bool {
+	n := p.lineEnding()
+	if n == 0 {
+		return false
+	}
+	if p.nestedCol == 0 {
+		p.advance(n)
+		return true
+	}
+	start := p.save()
+	p.advance(n)
+	for (p.peek() == ' ' || p.peek() == '\t') && p.col < p.nestedCol {
+		p.advance(1)
+	}
+	// Blank lines don't end a nested block.
+	if p.col < p.nestedCol && p.lineEnding() == 0 && !p.eof() {
+		p.restore(start)
+		return false
+	}
+	return true
+}
+
+// atLineStart reports if only spaces or tabs precede offset on its line.
+func (p *templateParser) atLineStart(offset int) bool {
+	for i := offset - 1; i >= 0; i-- {
+		switch p.src[i] {
+		case ' ', '\t':
+		case '\n', '\r':
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parse parses the whole template.
+func (p *templateParser) parse() ([]tnode, error) {
+	nodes, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if !p.eof() {
+		pos := p.pos()
+		if kw, ok := p.peekKeyword(); ok {
+			return nil, p.errorf(pos, "unexpected %q", kw)
+		}
+		return nil, p.errorf(pos, "unexpected %q", p.peek())
+	}
+	return nodes, nil
+}
+
+// parseNodes parses template content until EOF or a keyword which
+// ends the enclosing block (else, elseif, endif, sep, endfor).
+func (p *templateParser) parseNodes() ([]tnode, error) {
+	nodes := []tnode{}
+	for !p.eof() {
+		switch {
+		case p.lineEnding() > 0:
+			if !p.endline() {
+				return nodes, nil
+			}
+			nodes = append(nodes, newlineNode{})
+		case p.hasPrefix("$$"):
+			p.advance(2)
+			nodes = append(nodes, textNode{text: "$", reflow: p.reflow})
+		case p.hasPrefix("$--"):
+			startCol := p.col
+			for !p.eof() && p.lineEnding() == 0 {
+				p.advance(1)
+			}
+			// A comment starting a line takes its line ending with it.
+			if startCol == 1 {
+				p.endline()
+			}
+		case p.peek() == '$':
+			if _, ok := p.peekKeyword(); ok {
+				return nodes, nil
+			}
+			node, err := p.parseDirective()
+			if err != nil {
+				return nil, err
+			}
+			if node != nil {
+				nodes = append(nodes, node)
+			}
+		default:
+			start := p.offset
+			for !p.eof() && p.peek() != '$' && p.lineEnding() == 0 {
+				p.advance(1)
+			}
+			nodes = append(nodes, textNode{text: p.src[start:p.offset], reflow: p.reflow})
+		}
+	}
+	return nodes, nil
+}
+
+// open consumes an opening delimiter returning the matching closing
+// delimiter.
+func (p *templateParser) open() (string, bool) {
+	if p.hasPrefix("${") {
+		p.advance(2)
+		p.skipSpaces()
+		return "}", true
+	}
+	if p.hasPrefix("$") {
+		p.advance(1)
+		p.skipSpaces()
+		return "$", true
+	}
+	return "", false
+}
+
+// close consumes optional spaces and the closing delimiter.
+func (p *templateParser) close(closer string) error {
+	p.skipSpaces()
+	if !p.hasPrefix(closer) {
+		if p.eof() {
+			return p.errorf(p.pos(), "expected %q, found end of template", closer)
+		}
+		return p.errorf(p.pos(), "expected %q, found %q", closer, p.peek())
+	}
+	p.advance(len(closer))
+	return nil
+}
+
+// identifier reads a variable name part.
+func (p *templateParser) identifier() string {
+	start := p.offset
+	if c := p.peek(); !isLetter(c) {
+		return ""
+	}
+	for c := p.peek(); isLetter(c) || isDigit(c) || c == '_' || c == '-'; c = p.peek() {
+		p.advance(1)
+	}
+	return p.src[start:p.offset]
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// peekKeyword reports if a block ending keyword directive starts at
+// the current position without consuming it.
+func (p *templateParser) peekKeyword() (string, bool) {
+	start := p.save()
+	defer p.restore(start)
+	if _, ok := p.open(); !ok {
+		return "", false
+	}
+	word := p.identifier()
+	switch word {
+	case "else", "endif", "sep", "endfor":
+		return word, true
+	case "elseif":
+		if p.peek() == '(' {
+			return word, true
+		}
+	}
+	return "", false
+}
+
+// keyword consumes a directive holding only the keyword word.
+func (p *templateParser) keyword(word string) error {
+	pos := p.pos()
+	closer, ok := p.open()
+	if !ok {
+		return p.errorf(pos, "expected $%s$", word)
+	}
+	if found := p.identifier(); found != word {
+		if found == "" {
+			return p.errorf(pos, "expected $%s$", word)
+		}
+		return p.errorf(pos, "expected $%s$, found %q", word, found)
+	}
+	return p.close(closer)
+}
+
+// parseDirective parses everything that starts with a "$" other than
+// escapes, comments and block ending keywords.
+func (p *templateParser) parseDirective() (tnode, error) {
+	pos := p.pos()
+	startOffset := p.offset
+	start := p.save()
+	closer, _ := p.open()
+	switch {
+	case p.peek() == '~':
+		p.advance(1)
+		if err := p.close(closer); err != nil {
+			return nil, err
+		}
+		p.reflow = !p.reflow
+		return nil, nil
+	case p.peek() == '^':
+		p.advance(1)
+		if err := p.close(closer); err != nil {
+			return nil, err
+		}
+		return p.parseNested(pos)
+	}
+	word := p.identifier()
+	if word == "" {
+		if p.eof() {
+			return nil, p.errorf(pos, "unclosed %q", "$")
+		}
+		return nil, p.errorf(p.pos(), "unexpected %q in directive", p.peek())
+	}
+	if (word == "if" || word == "for") && p.peek() == '(' {
+		p.restore(start)
+		if word == "if" {
+			return p.parseConditional(pos)
+		}
+		return p.parseLoop(pos)
+	}
+	if p.peek() == '(' {
+		// Bare partial, e.g. ${ styles() }
+		p.restore(start)
+		p.open()
+		node, err := p.parsePartial(nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.close(closer); err != nil {
+			return nil, err
+		}
+		return p.nesting(pos, startOffset, node), nil
+	}
+	p.restore(start)
+	p.open()
+	v, err := p.parseVar()
+	if err != nil {
+		return nil, err
+	}
+	var node tnode
+	switch {
+	case p.peek() == ':':
+		p.advance(1)
+		node, err = p.parsePartial(v)
+		if err != nil {
+			return nil, err
+		}
+	case p.peek() == '[':
+		sep, err := p.parseSep()
+		if err != nil {
+			return nil, err
+		}
+		node = &loopNode{
+			pos:  pos,
+			v:    v,
+			body: []tnode{&interpNode{pos: pos, v: &tvar{path: []string{"it"}}}},
+			sep:  sep,
+		}
+	default:
+		node = &interpNode{pos: pos, v: v}
+	}
+	if err := p.close(closer); err != nil {
+		return nil, err
+	}
+	return p.nesting(pos, startOffset, node), nil
+}
+
+// nesting wraps a node which is alone on an indented line so that
+// multi-line values keep the indentation.
+func (p *templateParser) nesting(pos position, startOffset int, node tnode) tnode {
+	if pos.Column == 1 || pos.Column == p.nestedCol {
+		return node
+	}
+	if p.atLineStart(startOffset) && p.lineEnding() > 0 {
+		return &nestNode{body: []tnode{node}}
+	}
+	return node
+}
+
+// parseVar parses a variable name followed by any pipes.
+func (p *templateParser) parseVar() (*tvar, error) {
+	pos := p.pos()
+	v := &tvar{}
+	for {
+		part := p.identifier()
+		if part == "" {
+			if p.eof() {
+				return nil, p.errorf(pos, "unclosed %q", "$")
+			}
+			return nil, p.errorf(p.pos(), "unexpected %q in variable name", p.peek())
+		}
+		if reservedWords[part] {
+			return nil, p.errorf(pos, "%q is a reserved word", part)
+		}
+		v.path = append(v.path, part)
+		if p.peek() != '.' {
+			break
+		}
+		p.advance(1)
+	}
+	pipes, err := p.parsePipes()
+	if err != nil {
+		return nil, err
+	}
+	v.pipes = pipes
+	return v, nil
+}
+
+// parsePipes parses zero or more `/pipe arg...` suffixes.
+func (p *templateParser) parsePipes() ([]pipeCall, error) {
+	pipes := []pipeCall{}
+	for p.peek() == '/' {
+		p.advance(1)
+		pc := pipeCall{pos: p.pos()}
+		pc.name = p.identifier()
+		if pc.name == "" {
+			return nil, p.errorf(pc.pos, "expected a pipe name after %q", "/")
+		}
+		for {
+			start := p.save()
+			p.skipSpaces()
+			if p.offset == start.offset {
+				break
+			}
+			arg, ok, err := p.pipeArg()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				p.restore(start)
+				break
+			}
+			pc.args = append(pc.args, arg)
+		}
+		pipes = append(pipes, pc)
+	}
+	return pipes, nil
+}
+
+// pipeArg parses a number or a double quoted string.
+func (p *templateParser) pipeArg() (string, bool, error) {
+	switch c := p.peek(); {
+	case isDigit(c):
+		start := p.offset
+		for isDigit(p.peek()) {
+			p.advance(1)
+		}
+		return p.src[start:p.offset], true, nil
+	case c == '"':
+		pos := p.pos()
+		p.advance(1)
+		sb := new(strings.Builder)
+		for {
+			if p.eof() || p.lineEnding() > 0 {
+				return "", false, p.errorf(pos, "unterminated string in pipe argument")
+			}
+			c := p.peek()
+			if c == '"' {
+				p.advance(1)
+				return sb.String(), true, nil
+			}
+			if c == '\\' && p.offset+1 < len(p.src) {
+				p.advance(1)
+				c = p.peek()
+			}
+			sb.WriteByte(c)
+			p.advance(1)
+		}
+	}
+	return "", false, nil
+}
+
+// parseSep parses a `[separator]` suffix.
+func (p *templateParser) parseSep() ([]tnode, error) {
+	pos := p.pos()
+	p.advance(1)
+	start := p.offset
+	for !p.eof() && p.peek() != ']' {
+		p.advance(1)
+	}
+	if p.eof() {
+		return nil, p.errorf(pos, "unclosed %q", "[")
+	}
+	text := p.src[start:p.offset]
+	p.advance(1)
+	return []tnode{textNode{text: text, reflow: p.reflow}}, nil
+}
+
+// parsePartial parses `name()` with an optional separator and pipes.
+// When v is not nil the partial is applied to each value of v.
+func (p *templateParser) parsePartial(v *tvar) (tnode, error) {
+	pos := p.pos()
+	start := p.offset
+	for c := p.peek(); isLetter(c) || isDigit(c) || strings.IndexByte("_-./", c) >= 0; c = p.peek() {
+		p.advance(1)
+	}
+	name := p.src[start:p.offset]
+	if name == "" || !p.hasPrefix("()") {
+		return nil, p.errorf(pos, "expected a partial name followed by ()")
+	}
+	p.advance(2)
+	var sep []tnode
+	if p.peek() == '[' {
+		var err error
+		if sep, err = p.parseSep(); err != nil {
+			return nil, err
+		}
+	}
+	pipes, err := p.parsePipes()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := p.loadPartial(pos, name)
+	if err != nil {
+		return nil, err
+	}
+	node := &partialNode{pos: pos, name: name, file: p.partialPath(name), pipes: pipes, nodes: nodes}
+	if v == nil {
+		return node, nil
+	}
+	return &loopNode{pos: pos, v: v, body: []tnode{node}, sep: sep}, nil
+}
+
+// partialPath finds a partial relative to the template using the
+// template's extension when the partial has none.
+func (p *templateParser) partialPath(name string) string {
+	if filepath.Ext(name) == "" {
+		name += filepath.Ext(p.name)
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(p.name), name)
+}
+
+// loadPartial reads and parses a partial, the final newline is dropped.
+func (p *templateParser) loadPartial(pos position, name string) ([]tnode, error) {
+	if p.depth >= maxPartialDepth {
+		return nil, p.errorf(pos, "partials nested more than %d deep", maxPartialDepth)
+	}
+	fName := p.partialPath(name)
+	src, err := ioutil.ReadFile(fName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, p.errorf(pos, "partial %q not found (%s)", name, fName)
+		}
+		return nil, p.errorf(pos, "partial %q, %s", name, err)
+	}
+	text := strings.TrimSuffix(strings.TrimSuffix(string(src), "\n"), "\r")
+	child := newTemplateParser(fName, text, p.depth+1)
+	return child.parse()
+}
+
+// parseConditional parses if(var) ... [elseif(var) ...] [else ...] endif.
+func (p *templateParser) parseConditional(pos position) (tnode, error) {
+	v, err := p.parseKeywordVar("if")
+	if err != nil {
+		return nil, err
+	}
+	// When the "if" ends a line the line ending after "endif" is
+	// swallowed too.
+	multiline := p.endline()
+	node := &condNode{pos: pos, v: v}
+	if node.then, err = p.parseNodes(); err != nil {
+		return nil, err
+	}
+	if node.els, err = p.parseElse(multiline); err != nil {
+		return nil, err
+	}
+	if err := p.keyword("endif"); err != nil {
+		return nil, p.unclosed(err, pos, "if", "endif")
+	}
+	if multiline {
+		p.endline()
+	}
+	return node, nil
+}
+
+// parseElse parses an optional else or elseif branch.
+func (p *templateParser) parseElse(multiline bool) ([]tnode, error) {
+	kw, ok := p.peekKeyword()
+	if !ok {
+		return nil, nil
+	}
+	switch kw {
+	case "else":
+		p.keyword("else")
+		if multiline {
+			p.endline()
+		}
+		return p.parseNodes()
+	case "elseif":
+		pos := p.pos()
+		v, err := p.parseKeywordVar("elseif")
+		if err != nil {
+			return nil, err
+		}
+		if multiline {
+			p.endline()
+		}
+		node := &condNode{pos: pos, v: v}
+		if node.then, err = p.parseNodes(); err != nil {
+			return nil, err
+		}
+		if node.els, err = p.parseElse(multiline); err != nil {
+			return nil, err
+		}
+		return []tnode{node}, nil
+	}
+	return nil, nil
+}
+
+// parseLoop parses for(var) ... [sep ...] endfor.
+func (p *templateParser) parseLoop(pos position) (tnode, error) {
+	v, err := p.parseKeywordVar("for")
+	if err != nil {
+		return nil, err
+	}
+	multiline := p.endline()
+	node := &loopNode{pos: pos, v: v}
+	if node.body, err = p.parseNodes(); err != nil {
+		return nil, err
+	}
+	if kw, ok := p.peekKeyword(); ok && kw == "sep" {
+		p.keyword("sep")
+		if multiline {
+			p.endline()
+		}
+		if node.sep, err = p.parseNodes(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.keyword("endfor"); err != nil {
+		return nil, p.unclosed(err, pos, "for", "endfor")
+	}
+	if multiline {
+		p.endline()
+	}
+	node.body = changeToIt(v, node.body)
+	node.sep = changeToIt(v, node.sep)
+	return node, nil
+}
+
+// unclosed improves the error reported when a block is never closed.
+func (p *templateParser) unclosed(err error, pos position, opener string, closer string) error {
+	if p.eof() {
+		return p.errorf(pos, "%s without matching %s", opener, closer)
+	}
+	return err
+}
+
+// parseKeywordVar parses a `$keyword(var)$` directive.
+func (p *templateParser) parseKeywordVar(word string) (*tvar, error) {
+	closer, _ := p.open()
+	p.identifier()
+	p.advance(1) // "("
+	p.skipSpaces()
+	v, err := p.parseVar()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.peek() != ')' {
+		return nil, p.errorf(p.pos(), "expected %q to close %s(", ")", word)
+	}
+	p.advance(1)
+	if err := p.close(closer); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// parseNested parses the content following $^$.
+func (p *templateParser) parseNested(pos position) (tnode, error) {
+	oldCol := p.nestedCol
+	p.nestedCol = pos.Column
+	defer func() {
+		p.nestedCol = oldCol
+	}()
+	body, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	return &nestNode{body: body}, nil
+}
+
+// changeToIt rewrites references to the loop variable inside a loop
+// body as references to "it", partials are left alone.
+func changeToIt(v *tvar, nodes []tnode) []tnode {
+	reletter := func(w *tvar) *tvar {
+		if len(w.path) < len(v.path) {
+			return w
+		}
+		for i, part := range v.path {
+			if w.path[i] != part {
+				return w
+			}
+		}
+		path := append([]string{"it"}, w.path[len(v.path):]...)
+		return &tvar{path: path, pipes: w.pipes}
+	}
+	out := make([]tnode, len(nodes))
+	for i, node := range nodes {
+		switch n := node.(type) {
+		case *interpNode:
+			out[i] = &interpNode{pos: n.pos, v: reletter(n.v)}
+		case *condNode:
+			out[i] = &condNode{pos: n.pos, v: reletter(n.v), then: changeToIt(v, n.then), els: changeToIt(v, n.els)}
+		case *loopNode:
+			out[i] = &loopNode{pos: n.pos, v: reletter(n.v), body: changeToIt(v, n.body), sep: changeToIt(v, n.sep)}
+		case *nestNode:
+			out[i] = &nestNode{body: changeToIt(v, n.body)}
+		default:
+			out[i] = node
+		}
+	}
+	return out
+}
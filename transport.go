@@ -0,0 +1,237 @@
+// transport.go holds the strategies used to hand a JSON or YAML document
+// to Pandoc as metadata.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Transport identifies how metadata is passed to Pandoc.
+type Transport int
+
+const (
+	// TransportAuto picks the safest transport available, a pipe
+	// where the OS supports /dev/fd otherwise a file in the OS temp
+	// directory.
+	TransportAuto Transport = iota
+
+	// TransportTempDir writes the metadata to a file in the OS temp
+	// directory (see os.TempDir) and passes it via --metadata-file.
+	TransportTempDir
+
+	// TransportDir writes the metadata to a file in the directory
+	// named by MetadataOptions.Dir and passes it via --metadata-file.
+	TransportDir
+
+	// TransportPipe hands Pandoc the read end of a pipe, named as
+	// /dev/fd/3, via --metadata-file. Nothing is written to disk.
+	TransportPipe
+
+	// TransportStdin writes the metadata as a YAML front matter block
+	// (`---\n<yaml>\n---`) to Pandoc's standard input. Pandoc must be
+	// reading Markdown from standard input for this to work.
+	TransportStdin
+)
+
+var transportNames = map[Transport]string{
+	TransportAuto:    "auto",
+	TransportTempDir: "tempdir",
+	TransportDir:     "dir",
+	TransportPipe:    "pipe",
+	TransportStdin:   "stdin",
+}
+
+// String returns the name of the transport as accepted by ParseTransport.
+func (t Transport) String() string {
+	if name, ok := transportNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("transport(%d)", int(t))
+}
+
+// ParseTransport maps a name ("auto", "tempdir", "dir", "pipe" or
+// "stdin") to a Transport.
+func ParseTransport(name string) (Transport, error) {
+	for t, s := range transportNames {
+		if strings.EqualFold(name, s) {
+			return t, nil
+		}
+	}
+	return TransportAuto, fmt.Errorf("unknown metadata transport %q", name)
+}
+
+// MetadataOptions controls how ApplyTemplateWithMetadata passes the
+// document to Pandoc. The zero value selects TransportAuto.
+type MetadataOptions struct {
+	// Transport is the strategy used.
+	Transport Transport
+
+	// Dir is the directory used by TransportDir.
+	Dir string
+}
+
+// Resolve returns the transport that will actually be used, mapping
+// TransportAuto to a concrete strategy.
+func (mo *MetadataOptions) Resolve() Transport {
+	if mo == nil || mo.Transport == TransportAuto {
+		if pipeSupported() {
+			return TransportPipe
+		}
+		return TransportTempDir
+	}
+	return mo.Transport
+}
+
+// pipeSupported reports if Pandoc can read an inherited file
+// descriptor through /dev/fd.
+func pipeSupported() bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	info, err := os.Stat("/dev/fd")
+	return err == nil && info.IsDir()
+}
+
+// metadata is an opened transport ready to be attached to a Pandoc
+// command.
+type metadata struct {
+	// args are prepended to the Pandoc arguments
+	args []string
+	// stdin, if not nil, becomes Pandoc's standard input
+	stdin io.Reader
+	// extraFiles are inherited by Pandoc starting with fd 3
+	extraFiles []*os.File
+	// started is called once Pandoc is running
+	started func()
+	// cleanup releases any resources, it is always called
+	cleanup func()
+}
+
+// openMetadata prepares src for Pandoc using the transport selected
+// by mo. A non-nil body, e.g. the Markdown of a page, is passed to
+// Pandoc on standard input.
+func openMetadata(mo *MetadataOptions, src []byte, body []byte) (*metadata, error) {
+	var (
+		m   *metadata
+		err error
+	)
+	switch t := mo.Resolve(); t {
+	case TransportTempDir:
+		m, err = metadataFile("", src)
+	case TransportDir:
+		if mo.Dir == "" {
+			return nil, fmt.Errorf("metadata transport %q requires a directory", t)
+		}
+		m, err = metadataFile(mo.Dir, src)
+	case TransportPipe:
+		m, err = metadataPipe(src)
+	case TransportStdin:
+		m = metadataStdin(src)
+	default:
+		return nil, fmt.Errorf("unsupported metadata transport %q", t)
+	}
+	if err != nil || body == nil {
+		return m, err
+	}
+	if m.stdin != nil {
+		// The body follows the front matter block.
+		m.stdin = io.MultiReader(m.stdin, bytes.NewReader(body))
+	} else {
+		m.stdin = bytes.NewReader(body)
+	}
+	return m, nil
+}
+
+// metadataFile writes src to a temp file in dir ("" being the OS
+// temp directory).
+func metadataFile(dir string, src []byte) (*metadata, error) {
+	f, err := os.CreateTemp(dir, "pandoc.*.json")
+	if err != nil {
+		return nil, err
+	}
+	tmpFile := f.Name()
+	cleanup := func() {
+		os.Remove(tmpFile)
+	}
+	if _, err := f.Write(src); err != nil {
+		f.Close()
+		cleanup()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &metadata{
+		args:    []string{"--metadata-file", tmpFile},
+		started: func() {},
+		cleanup: cleanup,
+	}, nil
+}
+
+// metadataPipe passes the read end of a pipe to Pandoc as fd 3 and
+// feeds src into the write end once Pandoc has started.
+func metadataPipe(src []byte) (*metadata, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	m := &metadata{
+		args:       []string{"--metadata-file", "/dev/fd/3"},
+		extraFiles: []*os.File{r},
+	}
+	feeding := false
+	m.started = func() {
+		feeding = true
+		// Pandoc holds its own copy of the read end now.
+		r.Close()
+		go func() {
+			w.Write(src)
+			w.Close()
+		}()
+	}
+	m.cleanup = func() {
+		r.Close()
+		if !feeding {
+			w.Close()
+		}
+	}
+	return m, nil
+}
+
+// metadataStdin wraps src in a YAML front matter block. JSON is valid
+// YAML so it can be passed through as is.
+func metadataStdin(src []byte) *metadata {
+	body := bytes.TrimSpace(src)
+	// Drop the document markers of a YAML document, we supply our own.
+	if bytes.HasPrefix(body, []byte("---")) {
+		body = bytes.TrimPrefix(body, []byte("---"))
+	}
+	for _, marker := range []string{"---", "..."} {
+		if bytes.HasSuffix(body, []byte("\n"+marker)) {
+			body = bytes.TrimSuffix(body, []byte(marker))
+		}
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString("---\n")
+	buf.Write(bytes.TrimSpace(body))
+	buf.WriteString("\n---\n")
+	return &metadata{
+		stdin:   buf,
+		started: func() {},
+		cleanup: func() {},
+	}
+}
@@ -0,0 +1,475 @@
+// walk.go renders a tree of JSON, YAML and Markdown files into a
+// matching tree of HTML pages.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultTemplateName is the template used for the files of a
+	// directory (and those below it) which don't name their own.
+	DefaultTemplateName = "default.tmpl"
+
+	// TemplateKey is the front matter (or document) field naming the
+	// template for a file. Relative names are relative to the file.
+	TemplateKey = "template"
+)
+
+// formatMarkdown marks Markdown files, they are not a data format so
+// DecodeDocument doesn't accept it.
+const formatMarkdown = "markdown"
+
+// walkExtensions are the file extensions Walk renders.
+var walkExtensions = map[string]string{
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".md":   formatMarkdown,
+}
+
+// WalkOptions controls which files Walk renders and how.
+type WalkOptions struct {
+	// Include, when not empty, limits the walk to files matching one
+	// of the globs, see MatchGlob
+	Include []string
+
+	// Exclude skips the files and directories matching one of the
+	// globs
+	Exclude []string
+
+	// Ext is the extension of the rendered files, ".html" when empty
+	Ext string
+
+	// Options are passed to Pandoc for each file
+	Options []string
+}
+
+// WalkItem is a file found by Walk.
+type WalkItem struct {
+	// Source is the file read
+	Source string
+
+	// Target is the file written
+	Target string
+
+	// Template is the template chosen for the file
+	Template string
+
+	// Err is set when the file could not be rendered
+	Err error
+}
+
+// Error implements the error interface.
+func (w *WalkItem) Error() string {
+	return fmt.Sprintf("%s, %s", w.Source, w.Err)
+}
+
+// Unwrap returns the underlying error.
+func (w *WalkItem) Unwrap() error {
+	return w.Err
+}
+
+// WalkReport lists what Walk wrote and what failed.
+type WalkReport struct {
+	// Files is the number of files found
+	Files int
+
+	// Written holds the files written in walk order
+	Written []string
+
+	// Failed holds the files which could not be rendered
+	Failed []*WalkItem
+
+	// Unchanged holds the targets which were up to date, see
+	// Renderer.Manifest
+	Unchanged []string
+
+	// Pruned holds the targets removed because their source was
+	// deleted
+	Pruned []string
+
+	// Skipped counts the files not rendered because an earlier file
+	// failed, see Renderer.FailFast
+	Skipped int
+}
+
+// Summary describes the outcome, one failure per line.
+func (w *WalkReport) Summary() string {
+	sb := new(strings.Builder)
+	fmt.Fprintf(sb, "rendered %d of %d files", len(w.Written), w.Files)
+	if len(w.Unchanged) > 0 {
+		fmt.Fprintf(sb, ", %d unchanged", len(w.Unchanged))
+	}
+	if len(w.Pruned) > 0 {
+		fmt.Fprintf(sb, ", %d pruned", len(w.Pruned))
+	}
+	if len(w.Failed) > 0 {
+		fmt.Fprintf(sb, ", %d failed", len(w.Failed))
+	}
+	if w.Skipped > 0 {
+		fmt.Fprintf(sb, ", %d skipped", w.Skipped)
+	}
+	for _, item := range w.Failed {
+		fmt.Fprintf(sb, "\n  %s", item.Error())
+	}
+	return sb.String()
+}
+
+// WalkPlan finds the files under src which Walk would render, works
+// out their targets under dst and chooses their templates without
+// rendering anything. A file which can't be read or has no template
+// has its Err set. Hidden files and directories (names starting with
+// ".") are skipped, as is dst when it is inside src.
+//
+// A file's template is, in order of preference,
+//
+// 1. the "template" field of its front matter (or of the document for
+//    JSON and YAML files)
+// 2. a sidecar template with the same base name, e.g. "about.tmpl"
+//    for "about.md"
+// 3. "default.tmpl" in its directory or the nearest one above it
+//    within src
+//
+//```
+//  items, err := r.WalkPlan("content", "htdocs", &pdtmpl.WalkOptions{
+//      Exclude: []string{"drafts/**"},
+//  })
+//  for _, item := range items {
+//      fmt.Printf("%s -> %s (%s)\n", item.Source, item.Target, item.Template)
+//  }
+//```
+//
+func (r *Renderer) WalkPlan(src string, dst string, opts *WalkOptions) ([]*WalkItem, error) {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+	ext := opts.Ext
+	if ext == "" {
+		ext = ".html"
+	}
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := globRegexp(pattern); err != nil {
+			return nil, err
+		}
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", src)
+	}
+	absDst, _ := filepath.Abs(dst)
+	items := []*WalkItem{}
+	owners := map[string]string{}
+	err = filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, name)
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if abs, _ := filepath.Abs(name); abs == absDst || strings.HasPrefix(d.Name(), ".") || matchAny(opts.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := walkExtensions[strings.ToLower(filepath.Ext(name))]; !ok || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if matchAny(opts.Exclude, rel) || (len(opts.Include) > 0 && !matchAny(opts.Include, rel)) {
+			return nil
+		}
+		target := strings.TrimSuffix(rel, path.Ext(rel)) + ext
+		item := &WalkItem{
+			Source: name,
+			Target: filepath.Join(dst, filepath.FromSlash(target)),
+		}
+		item.Template, item.Err = chooseTemplate(src, name)
+		if item.Err == nil {
+			if other, ok := owners[item.Target]; ok {
+				item.Err = fmt.Errorf("same target as %s", other)
+			} else {
+				owners[item.Target] = name
+			}
+		}
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// Walk renders the files found by WalkPlan writing each to its target,
+// creating directories as needed. JSON and YAML files are rendered
+// with RenderFile, Markdown files with RenderMarkdown. Files are
+// rendered concurrently, see RenderJobs, the report lists them in walk
+// order. A file which fails is added to the report's Failed list and
+// the others are still rendered, unless the Renderer has FailFast set,
+// then the first failure stops the walk and is returned as the error.
+//
+// When the Renderer has a Manifest a file is only rendered when its
+// target is missing or the file, its template, the template's partials,
+// the files named by the options (e.g. "--lua-filter"), the options or
+// the Pandoc version changed since the last walk, unless Force is set.
+// The targets of deleted files are removed.
+//
+//```
+//  report, err := r.Walk(ctx, "content", "htdocs", nil)
+//  if err != nil {
+//      // ... handle error
+//  }
+//  fmt.Println(report.Summary())
+//```
+//
+func (r *Renderer) Walk(ctx context.Context, src string, dst string, opts *WalkOptions) (*WalkReport, error) {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+	items, err := r.WalkPlan(src, dst, opts)
+	report := &WalkReport{Files: len(items)}
+	if err != nil {
+		return report, err
+	}
+	var (
+		m    *Manifest
+		deps *dependencies
+	)
+	if r.Manifest != "" {
+		if m, err = ReadManifest(r.Manifest); err != nil {
+			return report, err
+		}
+		deps = r.newDependencies(m)
+	}
+	skipped := make([]bool, len(items))
+	unchanged := make([]bool, len(items))
+	first := r.runJobs(ctx, len(items), func(ctx context.Context, i int) error {
+		item := items[i]
+		var entry *ManifestEntry
+		if item.Err == nil && m != nil {
+			isMarkdown := walkExtensions[strings.ToLower(filepath.Ext(item.Source))] == formatMarkdown
+			entry = deps.entry(item.Source, isMarkdown, item.Template, opts.Options)
+			if !r.Force && m.fresh(item.Target, entry) {
+				unchanged[i] = true
+				return nil
+			}
+		}
+		if item.Err == nil {
+			item.Err = r.renderWalkItem(ctx, item, opts.Options)
+		}
+		if item.Err != nil {
+			if m != nil {
+				m.set(item.Target, nil)
+			}
+			return item
+		}
+		if m != nil {
+			m.set(item.Target, entry)
+		}
+		return nil
+	}, func(i int) {
+		skipped[i] = true
+	})
+	for i, item := range items {
+		switch {
+		case skipped[i]:
+			report.Skipped++
+		case unchanged[i]:
+			report.Unchanged = append(report.Unchanged, item.Target)
+		case item.Err != nil:
+			report.Failed = append(report.Failed, item)
+		default:
+			report.Written = append(report.Written, item.Target)
+		}
+	}
+	if m != nil {
+		// Remove the targets of deleted files then save what was
+		// rendered, even after a failure.
+		report.Pruned, err = m.prune(func(target string, entry *ManifestEntry) bool {
+			if entry.Source == "" {
+				return false
+			}
+			_, err := os.Stat(m.path(entry.Source))
+			return os.IsNotExist(err)
+		})
+		if err == nil {
+			err = m.Write()
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return report, first
+	}
+	if err := ctx.Err(); err != nil {
+		return report, interrupted(err)
+	}
+	return report, nil
+}
+
+// renderSource renders a JSON, YAML or Markdown file.
+func (r *Renderer) renderSource(ctx context.Context, name string, template string, options []string) ([]byte, error) {
+	if walkExtensions[strings.ToLower(filepath.Ext(name))] == formatMarkdown {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return r.RenderMarkdown(ctx, src, template, options)
+	}
+	return r.RenderFile(ctx, name, template, options)
+}
+
+// renderWalkItem renders a file and writes its target.
+func (r *Renderer) renderWalkItem(ctx context.Context, item *WalkItem, options []string) error {
+	out, err := r.renderSource(ctx, item.Source, item.Template, options)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(item.Target), 0775); err != nil {
+		return err
+	}
+	if r.Logger != nil {
+		r.Logger.Printf("writing %s", item.Target)
+	}
+	return ioutil.WriteFile(item.Target, out, 0664)
+}
+
+// chooseTemplate picks the template for a file under root.
+func chooseTemplate(root string, name string) (string, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(name)
+	// 1. the file names its template
+	var data map[string]interface{}
+	if format := walkExtensions[strings.ToLower(filepath.Ext(name))]; format == formatMarkdown {
+		front, _ := SplitFrontMatter(src)
+		data, err = decodeFrontMatter(front)
+	} else {
+		data, err = DecodeDocument(src, format)
+	}
+	if err != nil {
+		return "", err
+	}
+	if v, ok := data[TemplateKey]; ok {
+		tmpl, ok := v.(string)
+		if !ok || tmpl == "" {
+			return "", fmt.Errorf("%q should name a template", TemplateKey)
+		}
+		if !filepath.IsAbs(tmpl) {
+			tmpl = filepath.Join(dir, tmpl)
+		}
+		return tmpl, nil
+	}
+	// 2. a sidecar template
+	sidecar := strings.TrimSuffix(name, filepath.Ext(name)) + ".tmpl"
+	if info, err := os.Stat(sidecar); err == nil && !info.IsDir() {
+		return sidecar, nil
+	}
+	// 3. the nearest directory default
+	root = filepath.Clean(root)
+	for {
+		tmpl := filepath.Join(dir, DefaultTemplateName)
+		if info, err := os.Stat(tmpl); err == nil && !info.IsDir() {
+			return tmpl, nil
+		}
+		if dir == root || dir == "." || dir == filepath.Dir(dir) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "", fmt.Errorf("no template, add %q to the front matter, a %s or a %s", TemplateKey, filepath.Base(sidecar), DefaultTemplateName)
+}
+
+// matchAny reports if name matches one of the globs.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports if a slash separated relative path matches a glob.
+// "*" matches within a path element, "**" matches across them, "?"
+// matches a character and "[...]" a character class. A glob without a
+// "/" is matched against the last element of the path so "*.md" matches
+// "blog/post.md".
+//
+//```
+//  pdtmpl.MatchGlob("drafts/**", "drafts/2022/idea.md") // true
+//  pdtmpl.MatchGlob("*.yaml", "data/site.yaml")        // true
+//```
+//
+func MatchGlob(pattern string, name string) bool {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	return re.MatchString(name)
+}
+
+// globRegexp converts a glob to a regular expression.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	sb := new(strings.Builder)
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(pattern[i:], ']')
+			if j < 2 {
+				return nil, fmt.Errorf("bad glob %q, unclosed [", pattern)
+			}
+			class := pattern[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += j
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("bad glob %q, %s", pattern, err)
+	}
+	return re, nil
+}
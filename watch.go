@@ -0,0 +1,256 @@
+// watch.go re-renders a content tree as its files change.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultWatchInterval is how often Watch looks for changes.
+	DefaultWatchInterval = 500 * time.Millisecond
+
+	// DefaultDebounce is how long files must stay unchanged before
+	// Watch renders, so saving several files renders once.
+	DefaultDebounce = 250 * time.Millisecond
+)
+
+// WatchOptions controls Watch.
+type WatchOptions struct {
+	// Walk selects the files rendered, see Walk
+	Walk WalkOptions
+
+	// Interval is how often the files are checked,
+	// DefaultWatchInterval when zero
+	Interval time.Duration
+
+	// Debounce is how long the files must stay unchanged before
+	// rendering, DefaultDebounce when zero
+	Debounce time.Duration
+
+	// Changed, if not nil, is called with the files which changed
+	// before they are rendered
+	Changed func(files []string)
+
+	// Rendered, if not nil, is called after each walk with its report
+	// and error
+	Rendered func(report *WalkReport, err error)
+}
+
+// fileStamp is what the watcher compares to spot a change.
+type fileStamp struct {
+	size int64
+	mod  time.Time
+}
+
+// stampFiles notes the size and modification time of each file, a
+// missing file has no stamp.
+func stampFiles(names []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(names))
+	for _, name := range names {
+		if info, err := os.Stat(name); err == nil && !info.IsDir() {
+			stamps[name] = fileStamp{size: info.Size(), mod: info.ModTime()}
+		}
+	}
+	return stamps
+}
+
+// changedFiles lists the files added, removed or modified between two
+// sets of stamps.
+func changedFiles(before map[string]fileStamp, after map[string]fileStamp) []string {
+	changed := []string{}
+	for name, stamp := range after {
+		if old, ok := before[name]; !ok || old != stamp {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// treeFiles lists the files under src skipping hidden files and
+// directories, and skip when it is inside src.
+func treeFiles(src string, skip string) []string {
+	absSkip := ""
+	if skip != "" {
+		absSkip, _ = filepath.Abs(skip)
+	}
+	files := []string{}
+	filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if abs, _ := filepath.Abs(name); abs == absSkip || (name != src && strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), ".") {
+			files = append(files, filepath.Clean(name))
+		}
+		return nil
+	})
+	return files
+}
+
+// watchList lists the files a walk of src depends on, every file in the
+// tree (sources, sidecar and default templates, filters) and the inputs
+// recorded in the manifest, such as templates and partials kept
+// elsewhere.
+func (r *Renderer) watchList(src string, dst string) []string {
+	files := treeFiles(src, dst)
+	if m, err := ReadManifest(r.Manifest); err == nil {
+		for _, entry := range m.Outputs {
+			for rel := range entry.Inputs {
+				files = append(files, filepath.Clean(m.path(rel)))
+			}
+		}
+	}
+	return uniqueStrings(files)
+}
+
+// pollFiles checks the files named by list every interval against
+// stamps (taken now when nil). Once the changes have settled for
+// debounce it calls changed then starts watching any new files. It
+// returns when ctx is cancelled.
+func pollFiles(ctx context.Context, interval time.Duration, debounce time.Duration, stamps map[string]fileStamp, list func() []string, changed func(files []string)) {
+	if stamps == nil {
+		stamps = stampFiles(list())
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := stampFiles(list())
+		files := changedFiles(stamps, now)
+		if len(files) == 0 {
+			continue
+		}
+		// Wait for the files to settle, an editor may write several.
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(debounce):
+			}
+			again := stampFiles(list())
+			more := changedFiles(now, again)
+			if len(more) == 0 {
+				break
+			}
+			files = append(files, more...)
+			now = again
+		}
+		changed(uniqueStrings(files))
+		// Keep the stamps taken before changed was called so changes
+		// made meanwhile are seen, add those of new files.
+		for name, stamp := range stampFiles(list()) {
+			if _, ok := now[name]; !ok {
+				now[name] = stamp
+			}
+		}
+		stamps = now
+	}
+}
+
+// Watch renders the tree under src into dst as Walk does then polls the
+// files for changes, walking again once they have stopped changing.
+// Only the outputs whose inputs changed are rendered again, including
+// changes to templates, partials and Lua filters outside of src, see
+// Renderer.Manifest. When the Renderer has no Manifest one is kept in
+// dst. A failed render is reported to opts.Rendered and watching
+// continues. Watch returns when ctx is cancelled.
+//
+//```
+//  ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+//  defer cancel()
+//  err := r.Watch(ctx, "content", "htdocs", &pdtmpl.WatchOptions{
+//      Rendered: func(report *pdtmpl.WalkReport, err error) {
+//          log.Println(report.Summary())
+//      },
+//  })
+//```
+//
+func (r *Renderer) Watch(ctx context.Context, src string, dst string, opts *WatchOptions) error {
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	interval, debounce := opts.Interval, opts.Debounce
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	rw := *r
+	if rw.Manifest == "" {
+		rw.Manifest = filepath.Join(dst, ManifestName)
+	}
+	// FailFast would stop the other pages being refreshed.
+	rw.FailFast = false
+	walk := func() {
+		report, err := rw.Walk(ctx, src, dst, &opts.Walk)
+		if opts.Rendered != nil && ctx.Err() == nil {
+			opts.Rendered(report, err)
+		}
+		// Later walks only render what changed.
+		rw.Force = false
+	}
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	list := func() []string {
+		return rw.watchList(src, dst)
+	}
+	// Take the stamps before the first walk so changes made while
+	// rendering are seen, then add the templates it found.
+	stamps := stampFiles(list())
+	walk()
+	for name, stamp := range stampFiles(list()) {
+		if _, ok := stamps[name]; !ok {
+			stamps[name] = stamp
+		}
+	}
+	pollFiles(ctx, interval, debounce, stamps, list, func(files []string) {
+		if opts.Changed != nil {
+			opts.Changed(files)
+		}
+		walk()
+	})
+	return nil
+}
+
+// uniqueStrings sorts a list dropping repeats.
+func uniqueStrings(list []string) []string {
+	sort.Strings(list)
+	out := list[:0]
+	for _, s := range list {
+		if len(out) == 0 || s != out[len(out)-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,748 @@
+// webform.go turns the form objects found in YAML blocks into HTML
+// forms.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// inputTypes are the element types rendered as an input element.
+var inputTypes = map[string]bool{
+	"checkbox":       true,
+	"color":          true,
+	"date":           true,
+	"datetime-local": true,
+	"email":          true,
+	"file":           true,
+	"hidden":         true,
+	"image":          true,
+	"month":          true,
+	"number":         true,
+	"password":       true,
+	"radio":          true,
+	"range":          true,
+	"reset":          true,
+	"search":         true,
+	"submit":         true,
+	"tel":            true,
+	"text":           true,
+	"time":           true,
+	"url":            true,
+	"week":           true,
+}
+
+// Attributes copied from an element to its control, in the order they
+// are written. A true value is written as a boolean attribute and a
+// false one left out.
+var (
+	formAttributes     = []string{"id", "name", "class", "action", "method", "encoding", "enctype", "target", "autocomplete", "novalidate"}
+	inputAttributes    = []string{"name", "class", "value", "required", "placeholdertext", "placeholder", "title", "pattern", "min", "max", "step", "minlength", "maxlength", "size", "accept", "multiple", "list", "autocomplete", "autofocus", "disabled", "readonly", "checked", "src", "alt", "form", "aria-label", "aria-labelledby"}
+	selectAttributes   = []string{"name", "class", "required", "title", "size", "multiple", "autocomplete", "autofocus", "disabled", "form", "aria-label", "aria-labelledby"}
+	textareaAttributes = []string{"name", "class", "required", "placeholdertext", "placeholder", "title", "minlength", "maxlength", "rows", "cols", "wrap", "autocomplete", "autofocus", "disabled", "readonly", "form", "aria-label", "aria-labelledby"}
+	buttonAttributes   = []string{"name", "class", "value", "title", "autofocus", "disabled", "form", "aria-label", "aria-labelledby"}
+	outputAttributes   = []string{"name", "class", "for", "form", "aria-label", "aria-labelledby"}
+	fieldsetAttributes = []string{"name", "class", "disabled", "form", "aria-label", "aria-labelledby"}
+)
+
+// autofillFields are the autocomplete field names, contactFields may
+// follow a kind of contact such as "mobile".
+var (
+	autofillFields = []string{
+		"name", "honorific-prefix", "given-name", "additional-name",
+		"family-name", "honorific-suffix", "nickname", "username",
+		"new-password", "current-password", "one-time-code",
+		"organization-title", "organization", "street-address",
+		"address-line1", "address-line2", "address-line3",
+		"address-level4", "address-level3", "address-level2",
+		"address-level1", "country", "country-name", "postal-code",
+		"cc-name", "cc-given-name", "cc-additional-name", "cc-family-name",
+		"cc-number", "cc-exp", "cc-exp-month", "cc-exp-year", "cc-csc",
+		"cc-type", "transaction-currency", "transaction-amount",
+		"language", "bday", "bday-day", "bday-month", "bday-year", "sex",
+		"url", "photo",
+	}
+	contactFields = []string{
+		"tel", "tel-country-code", "tel-national", "tel-area-code",
+		"tel-local", "tel-local-prefix", "tel-local-suffix",
+		"tel-extension", "email", "impp",
+	}
+)
+
+// WebFormOptions controls how webforms are rendered.
+type WebFormOptions struct {
+	// Strict fails a form with a control which has no accessible name,
+	// i.e. no "label", "aria-label", "aria-labelledby" or "title", or
+	// with a fieldset or group which has no legend
+	Strict bool
+}
+
+// attributeNames maps the form schema's names to HTML's where they
+// differ.
+var attributeNames = map[string]string{
+	"encoding":        "enctype",
+	"placeholdertext": "placeholder",
+}
+
+// formOption is an entry of an element's options, or a group of
+// entries when Options isn't nil.
+type formOption struct {
+	Value    string
+	Label    string
+	Selected bool
+	Disabled bool
+	Options  []formOption
+}
+
+// formOptions reads an element's options in the order given. They may
+// be a list of values, a list of objects with "value", "label",
+// "selected" (or "checked") and "disabled" attributes, or an object
+// mapping values to labels which are sorted by value. An object in the
+// list with "options" is a group of options with a "label".
+//
+//```
+//  options:
+//    - { value: ca, label: California, selected: true }
+//    - { value: zz, label: Elsewhere, disabled: true }
+//    - label: East
+//      options:
+//        - { value: ny, label: New York }
+//```
+//
+func formOptions(val interface{}) []formOption {
+	options := []formOption{}
+	switch val := val.(type) {
+	case []interface{}:
+		for _, item := range val {
+			if m, ok := item.(map[string]interface{}); ok {
+				option := formOption{}
+				if v, ok := m["value"]; ok {
+					option.Value = fmt.Sprintf("%v", v)
+				}
+				option.Label = option.Value
+				if label, ok := m["label"]; ok {
+					option.Label = fmt.Sprintf("%v", label)
+				}
+				option.Selected, _ = m["selected"].(bool)
+				if checked, ok := m["checked"].(bool); ok {
+					option.Selected = checked
+				}
+				option.Disabled, _ = m["disabled"].(bool)
+				if group, ok := m["options"]; ok {
+					option.Options = formOptions(group)
+				}
+				options = append(options, option)
+			} else {
+				v := fmt.Sprintf("%v", item)
+				options = append(options, formOption{Value: v, Label: v})
+			}
+		}
+	case map[string]interface{}:
+		values := make([]string, 0, len(val))
+		for v := range val {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+		for _, v := range values {
+			options = append(options, formOption{Value: v, Label: fmt.Sprintf("%v", val[v])})
+		}
+	}
+	return options
+}
+
+// selectedValues lists the values an element starts with, for
+// checkboxes and multiple selects the value may be a list.
+func selectedValues(elem map[string]interface{}) map[string]bool {
+	selected := map[string]bool{}
+	switch val := elem["value"].(type) {
+	case nil:
+	case []interface{}:
+		for _, v := range val {
+			selected[fmt.Sprintf("%v", v)] = true
+		}
+	default:
+		selected[fmt.Sprintf("%v", val)] = true
+	}
+	return selected
+}
+
+// formAttr is an attribute of a formNode, a Bool attribute has no
+// value.
+type formAttr struct {
+	Name  string
+	Value string
+	Bool  bool
+}
+
+// formNode is an element of a rendered form. A node without a Tag is
+// its Text, or when it has Children they are written on one line, or a
+// line each when it is a Block.
+type formNode struct {
+	Tag      string
+	Attrs    []formAttr
+	Text     string
+	Children []*formNode
+
+	// Block writes each child on a line of its own
+	Block bool
+}
+
+// voidElements have no content or end tag.
+var voidElements = map[string]bool{
+	"input": true,
+}
+
+// element returns a node for an HTML element.
+func element(tag string) *formNode {
+	return &formNode{Tag: tag}
+}
+
+// formText returns a node for text.
+func formText(s string) *formNode {
+	return &formNode{Text: s}
+}
+
+// fragment returns a node writing its children on one line.
+func fragment(children ...*formNode) *formNode {
+	return &formNode{Children: children}
+}
+
+// set adds an attribute, one already set is left as it is.
+func (n *formNode) set(name string, value string) *formNode {
+	if !n.has(name) {
+		n.Attrs = append(n.Attrs, formAttr{Name: name, Value: value})
+	}
+	return n
+}
+
+// setBool adds a boolean attribute.
+func (n *formNode) setBool(name string) *formNode {
+	if !n.has(name) {
+		n.Attrs = append(n.Attrs, formAttr{Name: name, Bool: true})
+	}
+	return n
+}
+
+// has reports if an attribute is set.
+func (n *formNode) has(name string) bool {
+	for _, attr := range n.Attrs {
+		if attr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends children.
+func (n *formNode) add(children ...*formNode) *formNode {
+	n.Children = append(n.Children, children...)
+	return n
+}
+
+// copyAttrs sets the keys of elem found in keys as attributes.
+func (n *formNode) copyAttrs(elem map[string]interface{}, keys []string) *formNode {
+	for _, k := range keys {
+		name := k
+		if to, ok := attributeNames[k]; ok {
+			name = to
+		}
+		switch val := elem[k].(type) {
+		case nil:
+		case bool:
+			if val {
+				n.setBool(name)
+			}
+		case []interface{}:
+			// e.g. the controls an output is for
+			parts := []string{}
+			for _, part := range val {
+				parts = append(parts, fmt.Sprintf("%v", part))
+			}
+			n.set(name, strings.Join(parts, " "))
+		default:
+			n.set(name, fmt.Sprintf("%v", val))
+		}
+	}
+	return n
+}
+
+// write writes the node as HTML, everything from the form is escaped.
+// A Block node's children are indented one tab more than indent.
+func (n *formNode) write(out *bytes.Buffer, indent string) {
+	if n.Tag == "" {
+		out.WriteString(html.EscapeString(n.Text))
+		for i, child := range n.Children {
+			if n.Block && i > 0 {
+				out.WriteString("\n" + indent)
+			}
+			child.write(out, indent)
+		}
+		return
+	}
+	out.WriteString("<" + n.Tag)
+	for _, attr := range n.Attrs {
+		if attr.Bool {
+			out.WriteString(" " + attr.Name)
+		} else {
+			out.WriteString(" " + attr.Name + `="` + html.EscapeString(attr.Value) + `"`)
+		}
+	}
+	out.WriteString(">")
+	if voidElements[n.Tag] {
+		return
+	}
+	out.WriteString(html.EscapeString(n.Text))
+	if n.Block {
+		out.WriteString("\n")
+		for _, child := range n.Children {
+			out.WriteString(indent + "\t")
+			child.write(out, indent+"\t")
+			out.WriteString("\n")
+		}
+		out.WriteString(indent)
+	} else {
+		for _, child := range n.Children {
+			child.write(out, indent)
+		}
+	}
+	out.WriteString("</" + n.Tag + ">")
+}
+
+// MkWebForm takes a map[string]interface{} and translates the form structure into HTML
+//
+// The form's "elements" are a list of objects, the "type" of each
+// picks the control rendered.
+//
+// - "fieldset" groups its own "elements" under a "legend"
+// - "button" is a button element, "kind" is its type (e.g. "submit"),
+//   its "label" (or "value") is its text
+// - "radio" and "checkbox" with "options" are a group of inputs sharing
+//   the element's "name" in a fieldset with the "label" as legend
+// - "datalist" lists "options" for the inputs which name its "id" as
+//   their "list"
+// - "output" shows a result computed "for" other controls
+// - "select" and "textarea"
+// - otherwise an HTML5 input type, e.g. "text", "date", "range",
+//   "color", "file" or "hidden", "text" when there is no type
+//
+// A control's "label" names it, with "hint", "help" and "error" text
+// shown after it and read by screen readers as its description. An
+// "error" of true is an empty container for a script or server to
+// fill. Required controls are marked with aria-required and an
+// "autocomplete" attribute must hold valid autofill tokens.
+//
+// All the text and attribute values from the form are escaped, and the
+// attributes are written in a fixed order so the same form always
+// renders the same HTML. Nothing is written when the form has an error.
+//
+//```
+//  elements:
+//    - type: fieldset
+//      legend: Contact
+//      elements:
+//        - id: email
+//          type: email
+//          label: Email
+//          hint: We only use it to reply
+//          autocomplete: email
+//          required: true
+//    - type: radio
+//      name: size
+//      label: Size
+//      options: [ small, medium, large ]
+//    - type: button
+//      kind: submit
+//      label: Send
+//```
+//
+func MkWebForm(out io.Writer, eout io.Writer, m map[string]interface{}) error {
+	return MkWebFormWithOptions(out, eout, m, nil)
+}
+
+// MkWebFormWithOptions renders a form as MkWebForm does, wo may be nil.
+//
+//```
+//  err := pdtmpl.MkWebFormWithOptions(os.Stdout, os.Stderr, form,
+//      &pdtmpl.WebFormOptions{Strict: true})
+//```
+//
+func MkWebFormWithOptions(out io.Writer, eout io.Writer, m map[string]interface{}, wo *WebFormOptions) error {
+	if wo == nil {
+		wo = &WebFormOptions{}
+	}
+	form := element("form").copyAttrs(m, formAttributes)
+	form.Block = true
+	if l, ok := m["elements"]; ok {
+		elements, ok := l.([]interface{})
+		if !ok {
+			return fmt.Errorf("elements should be a list")
+		}
+		controls, err := formControls(elements, wo)
+		if err != nil {
+			return err
+		}
+		form.add(controls...)
+	}
+	buf := &bytes.Buffer{}
+	form.write(buf, "")
+	buf.WriteString("\n")
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// formControls turns a list of form elements into nodes.
+func formControls(elements []interface{}, wo *WebFormOptions) ([]*formNode, error) {
+	nodes := []*formNode{}
+	for i, item := range elements {
+		elem, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("element %d is not an object", i+1)
+		}
+		node, err := formControl(elem, wo)
+		if err != nil {
+			return nil, fmt.Errorf("element %d, %s", i+1, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// formControl turns a form element into a node.
+func formControl(elem map[string]interface{}, wo *WebFormOptions) (*formNode, error) {
+	eId, _ := elem["id"].(string)
+	eType, ok := elem["type"].(string)
+	if !ok {
+		eType = "text"
+	}
+	if v, ok := elem["autocomplete"]; ok {
+		if err := checkAutocomplete(fmt.Sprintf("%v", v)); err != nil {
+			return nil, err
+		}
+	}
+	var control *formNode
+	switch eType {
+	case "fieldset":
+		fieldset := element("fieldset").copyAttrs(elem, append([]string{"id"}, fieldsetAttributes...))
+		fieldset.Block = true
+		if legend, ok := elem["legend"].(string); ok {
+			fieldset.add(element("legend").add(formText(legend)))
+		}
+		if l, ok := elem["elements"]; ok {
+			elements, ok := l.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("elements should be a list")
+			}
+			children, err := formControls(elements, wo)
+			if err != nil {
+				return nil, err
+			}
+			fieldset.add(children...)
+		}
+		if wo.Strict && !hasText(elem, "legend", "aria-label", "aria-labelledby") {
+			return nil, fmt.Errorf("fieldset has no legend")
+		}
+		return described(elem, fieldset, fieldset)
+	case "button":
+		kind, ok := elem["kind"].(string)
+		if !ok {
+			kind = "button"
+		}
+		if kind != "button" && kind != "submit" && kind != "reset" {
+			return nil, fmt.Errorf("unknown button kind %q", kind)
+		}
+		text, ok := elem["label"].(string)
+		if !ok {
+			text = kind
+			if v, ok := elem["value"]; ok {
+				text = fmt.Sprintf("%v", v)
+			}
+		}
+		button := element("button").set("type", kind).copyAttrs(elem, append([]string{"id"}, buttonAttributes...))
+		return button.add(formText(text)), nil
+	case "datalist":
+		if eId == "" {
+			return nil, fmt.Errorf("datalist needs an id")
+		}
+		datalist := element("datalist").set("id", eId)
+		datalist.Block = true
+		options := formOptions(elem["options"])
+		if err := flatOptions(options); err != nil {
+			return nil, err
+		}
+		for _, option := range options {
+			datalist.add(element("option").set("value", option.Value).add(formText(option.Label)))
+		}
+		return datalist, nil
+	case "output":
+		output := element("output").copyAttrs(elem, append([]string{"id"}, outputAttributes...))
+		if v, ok := elem["value"]; ok {
+			output.add(formText(fmt.Sprintf("%v", v)))
+		}
+		control = output
+	case "radio", "checkbox":
+		if options, ok := elem["options"]; ok {
+			return formGroup(elem, eType, formOptions(options), wo)
+		}
+	case "select":
+		sel := element("select").copyAttrs(elem, append([]string{"id"}, selectAttributes...))
+		sel.Block = true
+		multiple, _ := elem["multiple"].(bool)
+		n, err := selectOptions(sel, formOptions(elem["options"]), selectedValues(elem), false)
+		if err != nil {
+			return nil, err
+		}
+		if n > 1 && !multiple {
+			return nil, fmt.Errorf("%d options selected, a select without multiple allows one", n)
+		}
+		control = sel
+	case "textarea":
+		textarea := element("textarea").copyAttrs(elem, append([]string{"id"}, textareaAttributes...))
+		if v, ok := elem["value"]; ok {
+			textarea.add(formText(fmt.Sprintf("%v", v)))
+		}
+		control = textarea
+	default:
+		if !inputTypes[eType] {
+			return nil, fmt.Errorf("unknown type %q", eType)
+		}
+	}
+	named := hasText(elem, "label", "aria-label", "aria-labelledby", "title")
+	if control == nil {
+		control = element("input").set("type", eType).copyAttrs(elem, append([]string{"id"}, inputAttributes...))
+		switch eType {
+		case "hidden":
+			return control, nil
+		case "submit", "reset":
+			// named by their value, or the browser's default
+			named = true
+		case "image":
+			named = named || hasText(elem, "alt")
+		}
+	}
+	if wo.Strict && !named {
+		return nil, fmt.Errorf("%s has no label", controlName(elem, eType))
+	}
+	if required, _ := elem["required"].(bool); required {
+		control.set("aria-required", "true")
+	}
+	return described(elem, control, labelled(elem, control))
+}
+
+// hasText reports if any of keys is non-empty text.
+func hasText(elem map[string]interface{}, keys ...string) bool {
+	for _, k := range keys {
+		if s, ok := elem[k].(string); ok && strings.TrimSpace(s) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// controlName names a control in an error message.
+func controlName(elem map[string]interface{}, eType string) string {
+	for _, k := range []string{"id", "name"} {
+		if s, ok := elem[k].(string); ok && s != "" {
+			return fmt.Sprintf("%s %q", eType, s)
+		}
+	}
+	return eType
+}
+
+// described adds the "hint", "help" and "error" paragraphs of elem
+// after node, or inside it for a fieldset, and lists them in control's
+// aria-describedby.
+func described(elem map[string]interface{}, control *formNode, node *formNode) (*formNode, error) {
+	base, _ := elem["id"].(string)
+	if base == "" {
+		base, _ = elem["name"].(string)
+	}
+	ids, paragraphs := []string{}, []*formNode{}
+	for _, k := range []string{"hint", "help", "error"} {
+		val, ok := elem[k]
+		if !ok || val == false {
+			continue
+		}
+		if base == "" {
+			return nil, fmt.Errorf("%s needs an id or name", k)
+		}
+		p := element("p").set("id", base+"-"+k).set("class", k)
+		if k == "error" {
+			p.set("aria-live", "polite")
+		}
+		if val != true {
+			p.add(formText(fmt.Sprintf("%v", val)))
+			if k == "error" {
+				control.set("aria-invalid", "true")
+			}
+		}
+		ids = append(ids, base+"-"+k)
+		paragraphs = append(paragraphs, p)
+	}
+	if len(ids) == 0 {
+		return node, nil
+	}
+	control.set("aria-describedby", strings.Join(ids, " "))
+	if node == control && control.Tag == "fieldset" {
+		return control.add(paragraphs...), nil
+	}
+	lines := fragment(append([]*formNode{node}, paragraphs...)...)
+	lines.Block = true
+	return lines, nil
+}
+
+// checkAutocomplete checks an autocomplete attribute holds "on", "off"
+// or autofill tokens, e.g. "shipping postal-code" or "section-work
+// mobile tel".
+func checkAutocomplete(value string) error {
+	tokens := strings.Fields(strings.ToLower(value))
+	if len(tokens) == 1 && (tokens[0] == "on" || tokens[0] == "off") {
+		return nil
+	}
+	if len(tokens) > 0 && tokens[len(tokens)-1] == "webauthn" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) > 0 && strings.HasPrefix(tokens[0], "section-") {
+		tokens = tokens[1:]
+	}
+	if len(tokens) > 0 && (tokens[0] == "shipping" || tokens[0] == "billing") {
+		tokens = tokens[1:]
+	}
+	switch len(tokens) {
+	case 1:
+		if matchAnyString(tokens[0], autofillFields) || matchAnyString(tokens[0], contactFields) {
+			return nil
+		}
+	case 2:
+		if matchAnyString(tokens[0], []string{"home", "work", "mobile", "fax", "pager"}) && matchAnyString(tokens[1], contactFields) {
+			return nil
+		}
+	}
+	return fmt.Errorf("autocomplete %q is not a list of autofill tokens", value)
+}
+
+// matchAnyString reports if s is in list.
+func matchAnyString(s string, list []string) bool {
+	for _, item := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// labelled puts a control after its label. A control without an id
+// is put inside its label.
+func labelled(elem map[string]interface{}, control *formNode) *formNode {
+	label, ok := elem["label"].(string)
+	if !ok {
+		return control
+	}
+	if eId, ok := elem["id"].(string); ok && eId != "" {
+		return fragment(element("label").set("for", eId).add(formText(label)), control)
+	}
+	return element("label").add(formText(label+" "), control)
+}
+
+// formGroup returns a group of radio buttons or checkboxes sharing a
+// name. An option is checked when it is selected or matches the
+// element's value.
+func formGroup(elem map[string]interface{}, eType string, options []formOption, wo *WebFormOptions) (*formNode, error) {
+	name, ok := elem["name"].(string)
+	if !ok {
+		name, _ = elem["id"].(string)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%s group needs a name or id", eType)
+	}
+	if err := flatOptions(options); err != nil {
+		return nil, err
+	}
+	checked := selectedValues(elem)
+	group := element("fieldset").copyAttrs(elem, []string{"id", "class", "disabled"})
+	group.Block = true
+	if wo.Strict && !hasText(elem, "label", "aria-label", "aria-labelledby") {
+		return nil, fmt.Errorf("%s has no label", controlName(elem, eType))
+	}
+	group.copyAttrs(elem, []string{"aria-label", "aria-labelledby"})
+	if eType == "radio" {
+		group.set("role", "radiogroup")
+		if required, _ := elem["required"].(bool); required {
+			group.set("aria-required", "true")
+		}
+	}
+	if label, ok := elem["label"].(string); ok {
+		group.add(element("legend").add(formText(label)))
+	}
+	for _, option := range options {
+		input := element("input").set("type", eType).set("name", name).set("value", option.Value)
+		if eType == "radio" {
+			// A required checkbox would have to be checked.
+			input.copyAttrs(elem, []string{"required"})
+		}
+		if option.Selected || checked[option.Value] {
+			input.setBool("checked")
+		}
+		if option.Disabled {
+			input.setBool("disabled")
+		}
+		group.add(element("label").add(input, formText(" "+option.Label)))
+	}
+	return described(elem, group, group)
+}
+
+// selectOptions adds options, and option groups unless inGroup, to a
+// select. It returns how many are selected.
+func selectOptions(parent *formNode, options []formOption, selected map[string]bool, inGroup bool) (int, error) {
+	n := 0
+	for _, option := range options {
+		if option.Options != nil {
+			if inGroup {
+				return n, fmt.Errorf("option group %q is inside another group", option.Label)
+			}
+			group := element("optgroup").set("label", option.Label)
+			group.Block = true
+			if option.Disabled {
+				group.setBool("disabled")
+			}
+			i, err := selectOptions(group, option.Options, selected, true)
+			if err != nil {
+				return n, err
+			}
+			n += i
+			parent.add(group)
+			continue
+		}
+		node := element("option").set("value", option.Value)
+		if option.Selected || selected[option.Value] {
+			node.setBool("selected")
+			n++
+		}
+		if option.Disabled {
+			node.setBool("disabled")
+		}
+		parent.add(node.add(formText(option.Label)))
+	}
+	return n, nil
+}
+
+// flatOptions checks options which can't be grouped have no groups.
+func flatOptions(options []formOption) error {
+	for _, option := range options {
+		if option.Options != nil {
+			return fmt.Errorf("option group %q is only allowed in a select", option.Label)
+		}
+	}
+	return nil
+}
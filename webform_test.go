@@ -0,0 +1,157 @@
+// webform_test.go checks the webform renderer against golden files.
+//
+// @Author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// copyright 2022 R. S. Doiel
+// All rights reserved.
+//
+// License under the 3-Clause BSD License
+// See https://opensource.org/licenses/BSD-3-Clause
+package pdtmpl
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	// 3rd Party libraries
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// readForm reads a form object from a YAML file.
+func readForm(t *testing.T, name string) map[string]interface{} {
+	t.Helper()
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := map[string]interface{}{}
+	if err := yaml.Unmarshal(src, &form); err != nil {
+		t.Fatalf("%s, %s", name, err)
+	}
+	return form
+}
+
+var reTag = regexp.MustCompile(`<(/?)([a-z]+)[^>]*>`)
+
+// checkWellFormed makes sure each element is closed in the right order,
+// and that no text or attribute has an unescaped quote or bracket.
+func checkWellFormed(t *testing.T, name string, page []byte) {
+	t.Helper()
+	stack := []string{}
+	for _, m := range reTag.FindAllSubmatch(page, -1) {
+		tag := string(m[2])
+		if voidElements[tag] {
+			if len(m[1]) > 0 {
+				t.Errorf("%s, end tag for void element %s", name, tag)
+			}
+			continue
+		}
+		if len(m[1]) == 0 {
+			stack = append(stack, tag)
+			continue
+		}
+		if len(stack) == 0 || stack[len(stack)-1] != tag {
+			t.Errorf("%s, unexpected </%s>, open elements %v", name, tag, stack)
+			return
+		}
+		stack = stack[:len(stack)-1]
+	}
+	if len(stack) > 0 {
+		t.Errorf("%s, unclosed elements %v", name, stack)
+	}
+	// With the tags and quoted attribute values removed only escaped
+	// text is left.
+	text := reTag.ReplaceAllString(string(page), "")
+	if strings.ContainsAny(text, `<>"`) {
+		t.Errorf("%s, unescaped text %q", name, text)
+	}
+	for _, m := range regexp.MustCompile(`="([^"]*)"`).FindAllStringSubmatch(string(page), -1) {
+		if strings.ContainsAny(m[1], `<>`) {
+			t.Errorf("%s, unescaped attribute value %q", name, m[1])
+		}
+	}
+}
+
+func TestMkWebFormGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "webform", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no forms found in testdata/webform")
+	}
+	for _, name := range files {
+		form := readForm(t, name)
+		out := &bytes.Buffer{}
+		if err := MkWebForm(out, ioutil.Discard, form); err != nil {
+			t.Errorf("%s, %s", name, err)
+			continue
+		}
+		checkWellFormed(t, name, out.Bytes())
+		// Map order changes each run, the output must not.
+		for i := 0; i < 10; i++ {
+			again := &bytes.Buffer{}
+			MkWebForm(again, ioutil.Discard, readForm(t, name))
+			if !bytes.Equal(out.Bytes(), again.Bytes()) {
+				t.Errorf("%s, output changed between renders\n%s\n%s", name, out, again)
+				break
+			}
+		}
+		golden := strings.TrimSuffix(name, ".yaml") + ".golden.html"
+		if *update {
+			if err := ioutil.WriteFile(golden, out.Bytes(), 0664); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s, %s (run go test -update)", name, err)
+			continue
+		}
+		if !bytes.Equal(expected, out.Bytes()) {
+			t.Errorf("%s, expected\n%s\ngot\n%s", name, expected, out)
+		}
+	}
+}
+
+func TestMkWebFormErrors(t *testing.T) {
+	for _, tc := range []struct {
+		form   string
+		strict bool
+		msg    string
+	}{
+		{"elements: [ { type: bogus } ]", false, `element 1, unknown type "bogus"`},
+		{"elements: [ { type: button, kind: go } ]", false, `unknown button kind "go"`},
+		{"elements: [ { type: datalist } ]", false, "datalist needs an id"},
+		{"elements: [ { type: radio, options: [ a ] } ]", false, "radio group needs a name or id"},
+		{"elements: [ { type: radio, name: r, options: [ { label: g, options: [ a ] } ] } ]", false, `option group "g" is only allowed in a select`},
+		{"elements: [ { type: select, value: [ a, b ], options: [ a, b ] } ]", false, "2 options selected"},
+		{"elements: [ { id: q, autocomplete: nonsense } ]", false, `autocomplete "nonsense"`},
+		{"elements: [ { help: Why } ]", false, "help needs an id or name"},
+		{"elements: [ { id: q } ]", true, `text "q" has no label`},
+		{"elements: [ { type: fieldset, elements: [] } ]", true, "fieldset has no legend"},
+		{"elements: [ { type: checkbox, name: c, options: [ a ] } ]", true, `checkbox "c" has no label`},
+		{"elements: [ { type: fieldset, legend: L, elements: [ { type: email } ] } ]", true, "element 1, element 1, email has no label"},
+	} {
+		form := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(tc.form), &form); err != nil {
+			t.Fatalf("%s, %s", tc.form, err)
+		}
+		out := &bytes.Buffer{}
+		err := MkWebFormWithOptions(out, ioutil.Discard, form, &WebFormOptions{Strict: tc.strict})
+		if err == nil || !strings.Contains(err.Error(), tc.msg) {
+			t.Errorf("%s, expected an error with %q, got %v", tc.form, tc.msg, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s, expected nothing written, got %s", tc.form, out)
+		}
+	}
+}